@@ -1,6 +1,7 @@
 package alerts
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -15,20 +16,37 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-// Alert contains an internal representation of an alert, which is also marshallable to JSON
+// Alert contains an internal representation of an alert, which is also marshallable to JSON.
+// Header, Description and HTMLBody are keyed by BCP-47 language tag (e.g. "pl", "en", "uk"),
+// so a single Alert can carry every language fetched for it.
 type Alert struct {
-	ID       string   `json:"id"`
-	Routes   []string `json:"routes"`
-	Effect   string   `json:"effect"`
-	Link     string   `json:"link"`
-	Title    string   `json:"title"`
-	Body     string   `json:"body"`
-	HTMLBody string   `json:"htmlbody"`
+	ID          string            `json:"id"`
+	Routes      []string          `json:"routes"`
+	Effect      string            `json:"effect"`
+	Link        string            `json:"link"`
+	Header      map[string]string `json:"header"`
+	Description map[string]string `json:"description"`
+	HTMLBody    map[string]string `json:"htmlbody"`
+
+	// Cause is guessed from the Polish source text (see classifyCause); unmatched text reports
+	// Alert_UNKNOWN_CAUSE rather than a random guess. SeverityLevel follows from Effect alone
+	// (see classifySeverity): Alert_WARNING for REDUCED_SERVICE, Alert_INFO otherwise.
+	Cause         gtfsrt.Alert_Cause         `json:"cause"`
+	SeverityLevel gtfsrt.Alert_SeverityLevel `json:"severity_level"`
+
+	// ActivePeriod is parsed out of the defaultLanguage Description, if it states an explicit
+	// date range (see parseActivePeriod). Left nil when no date could be found.
+	ActivePeriod []*gtfsrt.TimeRange `json:"active_period"`
 }
 
-// alertFromRssItem extracts basic data from an RssItem and puts them into an Alert
-func alertFromRssItem(r *rssItem, routeMap map[string]sort.StringSlice) (a *Alert, err error) {
-	a = &Alert{}
+// alertFromRssItem extracts basic data from an RssItem (fetched in the given language)
+// and puts them into a new Alert
+func alertFromRssItem(r *rssItem, lang string, routeMap map[string]sort.StringSlice) (a *Alert, err error) {
+	a = &Alert{
+		Header:      map[string]string{},
+		Description: map[string]string{},
+		HTMLBody:    map[string]string{},
+	}
 
 	// Extract the ID
 	if idMatch := regexID.FindStringIndex(r.GUID); idMatch != nil {
@@ -49,29 +67,67 @@ func alertFromRssItem(r *rssItem, routeMap map[string]sort.StringSlice) (a *Aler
 	// Extract other data
 	a.Effect = r.Type
 	a.Link = htmlCleaner.Sanitize(r.Link)
-	a.Title = htmlCleaner.Sanitize(r.Description)
+	a.Header[lang] = htmlCleaner.Sanitize(r.Description)
 
 	// Extract affected routes from the title
-	if strings.Contains(r.Title, ":") {
-		routesString := strings.SplitN(r.Title, ":", 2)[1]
+	a.Routes = extractRoutesFromTitle(r.Title, routeMap)
 
-		for _, route := range regexRoute.FindAllString(routesString, -1) {
-			validRoute := false
+	// Guess the cause and severity from the title/description, same as wtp.waw.pl's own
+	// category icons do for Routes via routesFromFlags
+	a.Cause = classifyCause(r.Title + " " + r.Description)
+	a.SeverityLevel = classifySeverity(a.Effect)
 
-			// Check if the route is mentioned in the GTFS
-			for _, routeSubList := range routeMap {
-				validRoute = validRoute || util.StringSliceHas(routeSubList, route)
-			}
+	return
+}
 
-			if validRoute {
-				a.Routes = append(a.Routes, route)
-			}
+// extractRoutesFromTitle parses the Warsaw convention of listing affected route short_names
+// before a colon in an alert's title (e.g. "106, 111: ..."), keeping only the tokens that
+// are actually mentioned in routeMap.
+func extractRoutesFromTitle(title string, routeMap map[string]sort.StringSlice) (routes []string) {
+	if !strings.Contains(title, ":") {
+		return
+	}
+	routesString := strings.SplitN(title, ":", 2)[1]
+
+	for _, route := range regexRoute.FindAllString(routesString, -1) {
+		validRoute := false
+
+		// Check if the route is mentioned in the GTFS
+		for _, routeSubList := range routeMap {
+			validRoute = validRoute || util.StringSliceHas(routeSubList, route)
+		}
+
+		if validRoute {
+			routes = append(routes, route)
 		}
 	}
+	return
+}
 
+// routesFromFlags maps a backend's category flags (e.g. wtp.waw.pl's "metro"/"tramwaje"/
+// "skm"/"kolej"/"autobusy" icons) to the route_ids of the corresponding GTFS route_type.
+func routesFromFlags(flags []string, routeMap map[string]sort.StringSlice) (routes []string) {
+	for _, flag := range flags {
+		switch flag {
+		case "metro":
+			routes = append(routes, routeMap["1"]...)
+		case "tramwaje":
+			routes = append(routes, routeMap["0"]...)
+		case "skm", "kolej":
+			routes = append(routes, routeMap["2"]...)
+		case "autobusy":
+			routes = append(routes, routeMap["3"]...)
+		}
+	}
 	return
 }
 
+// mergeRssItemTranslation adds the header text of a translated rssItem, describing
+// the same alert as a, under the given language.
+func (a *Alert) mergeRssItemTranslation(r *rssItem, lang string) {
+	a.Header[lang] = htmlCleaner.Sanitize(r.Description)
+}
+
 // makeEntitySelector creates a GTFS-RT []*EntitySelector that "select" applicable routes
 func (a *Alert) makeEntitySelector() []*gtfsrt.EntitySelector {
 	var entities []*gtfsrt.EntitySelector
@@ -99,50 +155,117 @@ func (a *Alert) AsProto() *gtfsrt.FeedEntity {
 	return &gtfsrt.FeedEntity{
 		Id: &a.ID,
 		Alert: &gtfsrt.Alert{
+			ActivePeriod:    a.ActivePeriod,
 			InformedEntity:  a.makeEntitySelector(),
+			Cause:           &a.Cause,
 			Effect:          a.makeAlertEffect(),
 			Url:             util.MakeTranslatedString(a.Link),
-			HeaderText:      util.MakeTranslatedString(a.Title),
-			DescriptionText: util.MakeTranslatedString(a.Body),
+			HeaderText:      util.MakeTranslatedStringMap(a.Header),
+			DescriptionText: util.MakeTranslatedStringMap(a.Description),
+			SeverityLevel:   &a.SeverityLevel,
 		},
 	}
 }
 
-// LoadExternal processes data located on the website saved in a.Link
-func (a *Alert) LoadExternal(client exclusiveHTTPClient, routeMap map[string]sort.StringSlice) (err error) {
-	doc, err := getWebsite(client, a.Link, a.ID)
+// LoadExternal processes data located on the website saved in a.Link, in the defaultLanguage,
+// and then repeats the same extraction for every other requested language, by following the
+// alternate-language link advertised on the defaultLanguage page. When a language has no
+// such native page and translate is non-nil, translate is used to derive its header and
+// description from the defaultLanguage plaintext instead of skipping the language outright.
+func (a *Alert) LoadExternal(ctx context.Context, client exclusiveHTTPClient, routeMap map[string]sort.StringSlice, languages []string, translate func(ctx context.Context, text, fromLang, toLang string) (string, error)) (err error) {
+	doc, err := getWebsite(ctx, client, a.Link, a.ID)
 	if err != nil {
 		return
 	}
 
 	// Process flags
 	if len(a.Routes) <= 0 {
-		flags := getAlertFlags(doc, a.Effect)
-		for _, flag := range flags {
-			switch flag {
-			case "metro":
-				a.Routes = append(a.Routes, routeMap["1"]...)
-			case "tramwaje":
-				a.Routes = append(a.Routes, routeMap["0"]...)
-			case "skm", "kolej":
-				a.Routes = append(a.Routes, routeMap["2"]...)
-			case "autobusy":
-				a.Routes = append(a.Routes, routeMap["3"]...)
-			}
-		}
+		a.Routes = routesFromFlags(getAlertFlags(doc, a.Effect), routeMap)
 	}
 
 	// Sanitize the document to make a usable htmlBody
-	a.HTMLBody, err = getAlertDesc(doc, a.Effect)
+	htmlBody, err := getAlertDesc(doc, a.Effect)
 	if err != nil {
 		return
 	}
+	a.HTMLBody[defaultLanguage] = htmlBody
 
 	// Strip the cleaned HTMLBody to make a plaintext description
-	a.Body, err = getAlertPlaintext(a.HTMLBody)
+	a.Description[defaultLanguage], err = getAlertPlaintext(htmlBody)
+	if err != nil {
+		return
+	}
+
+	// The full description is a better source for an explicit date range than the RSS
+	// title/blurb alertFromRssItem had to work with
+	a.ActivePeriod = parseActivePeriod(a.Description[defaultLanguage])
+
+	// Repeat the extraction for every other requested language, following the
+	// hreflang alternate link advertised on the page we've just scraped
+	for _, lang := range languages {
+		if lang == defaultLanguage {
+			continue
+		}
+
+		altLink, ok := findAlternateLink(doc, lang)
+		if ok {
+			err = a.loadExternalTranslation(ctx, client, altLink, lang)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		// No native page for this language - fall back to auto-translation, if configured
+		if translate != nil {
+			err = a.autoTranslate(ctx, translate, lang)
+			if err != nil {
+				return
+			}
+		}
+	}
+
 	return
 }
 
+// autoTranslate fills in a.Header and a.Description for lang by running the
+// defaultLanguage plaintext through translate. a.HTMLBody is intentionally left unset,
+// as auto-translation isn't expected to preserve markup.
+func (a *Alert) autoTranslate(ctx context.Context, translate func(ctx context.Context, text, fromLang, toLang string) (string, error), lang string) error {
+	header, err := translate(ctx, a.Header[defaultLanguage], defaultLanguage, lang)
+	if err != nil {
+		return fmt.Errorf("auto-translating header of %s to %q: %w", a.ID, lang, err)
+	}
+	a.Header[lang] = header
+
+	description, err := translate(ctx, a.Description[defaultLanguage], defaultLanguage, lang)
+	if err != nil {
+		return fmt.Errorf("auto-translating description of %s to %q: %w", a.ID, lang, err)
+	}
+	a.Description[lang] = description
+
+	return nil
+}
+
+// loadExternalTranslation fetches altLink (an alternate-language version of a.Link) and
+// merges its description/htmlBody into the Alert under the given language. Header[lang] is
+// not set here; it comes from the translated RSS item (see mergeRssItemTranslation).
+func (a *Alert) loadExternalTranslation(ctx context.Context, client exclusiveHTTPClient, altLink string, lang string) error {
+	doc, err := getWebsite(ctx, client, altLink, a.ID)
+	if err != nil {
+		return err
+	}
+
+	htmlBody, err := getAlertDesc(doc, a.Effect)
+	if err != nil {
+		return err
+	}
+	a.HTMLBody[lang] = htmlBody
+
+	a.Description[lang], err = getAlertPlaintext(htmlBody)
+	return err
+}
+
 // AlertContainer is a container for multiple alerts, marshallable to JSON
 type AlertContainer struct {
 	Timestamp time.Time `json:"-"`
@@ -159,13 +282,15 @@ func (ac *AlertContainer) AsProto() *gtfsrt.FeedMessage {
 	return msg
 }
 
-// LoadExternal asynchronously calls LoadExternal on all its alerts
-func (ac *AlertContainer) LoadExternal(client exclusiveHTTPClient, routeMap map[string]sort.StringSlice, throwErrors bool) error {
+// EnrichAll asynchronously calls enrich on every alert in the container - e.g. a closure
+// running Backend.EnrichAlert with whichever backend originally listed that alert - merging
+// any errors the same way a single EnrichAlert call would report one.
+func (ac *AlertContainer) EnrichAll(ctx context.Context, enrich func(context.Context, *Alert) error, throwErrors bool) error {
 	// Make synchronization primitives
 	wg := &sync.WaitGroup{}
 	errCh := make(chan error, len(ac.Alerts)+1)
 
-	// Make a goroutine for all LoadExternal
+	// Make a goroutine for all enrich calls
 	for _, a := range ac.Alerts {
 		// Log & update the waitgroup
 		log.Printf("Fetching alert desc from %v\n", a.Link)
@@ -174,7 +299,7 @@ func (ac *AlertContainer) LoadExternal(client exclusiveHTTPClient, routeMap map[
 		// Call a goroutine to load external data
 		go func(a *Alert) {
 			defer wg.Done()
-			err := a.LoadExternal(client, routeMap)
+			err := enrich(ctx, a)
 
 			// errors are only passed through if requested and if not nil
 			if throwErrors && err != nil {
@@ -0,0 +1,96 @@
+package alerts
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Backend is a pluggable source of transit alerts. A single run of Make/MakeContext can
+// combine several backends (see Options.Backends), so alerts from multiple operators or
+// feeds can be merged into one GTFS-Realtime output.
+type Backend interface {
+	// ListAlerts returns the alerts currently published by this backend, with ID, Link,
+	// Effect and (where cheaply derivable, e.g. from a title) Routes filled in - everything
+	// needed to decide whether the alert is worth keeping before the more expensive
+	// EnrichAlert call.
+	ListAlerts(ctx context.Context, routeMap map[string]sort.StringSlice, languages []string, throwErrors bool) ([]*Alert, error)
+
+	// EnrichAlert fills in Description, HTMLBody and any remaining Header entries for every
+	// requested language, for an alert previously returned by this same backend's
+	// ListAlerts.
+	EnrichAlert(ctx context.Context, a *Alert, routeMap map[string]sort.StringSlice, languages []string) error
+}
+
+// wtpBackend scrapes wtp.waw.pl, the Warsaw transit authority's own site: the urlChanges and
+// urlImpediments RSS feeds for the list of currently active alerts, and each alert's own page
+// on the site for its full description. It's the default Backend when Options.Backends is
+// left empty.
+type wtpBackend struct {
+	client    exclusiveHTTPClient
+	translate func(ctx context.Context, text, fromLang, toLang string) (string, error)
+}
+
+// NewWtpBackend builds the default wtp.waw.pl-backed Backend
+func NewWtpBackend(client *http.Client, translate func(ctx context.Context, text, fromLang, toLang string) (string, error)) Backend {
+	return &wtpBackend{
+		client:    exclusiveHTTPClient{m: &sync.Mutex{}, c: client},
+		translate: translate,
+	}
+}
+
+// ListAlerts implements Backend
+func (b *wtpBackend) ListAlerts(ctx context.Context, routeMap map[string]sort.StringSlice, languages []string, throwErrors bool) ([]*Alert, error) {
+	log.Println("Fetching RSS feeds")
+	items, err := allRssItems(ctx, b.client, defaultLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert RSS items to Alert objects, indexed by GUID for translation merging below
+	log.Println("Casting RSS items to Alert objects")
+	alerts := make([]*Alert, 0, len(items))
+	byGUID := make(map[string]*Alert, len(items))
+	for _, item := range items {
+		a, err := alertFromRssItem(item, defaultLanguage, routeMap)
+		if err != nil {
+			return nil, err
+		}
+
+		alerts = append(alerts, a)
+		byGUID[item.GUID] = a
+	}
+
+	// Fetch the same feeds in every other requested language and merge the
+	// translated header text into the matching Alert by GUID
+	for _, lang := range languages {
+		if lang == defaultLanguage {
+			continue
+		}
+
+		log.Printf("Fetching RSS feeds for language %q\n", lang)
+		translatedItems, err := allRssItems(ctx, b.client, lang)
+		if err != nil {
+			if throwErrors {
+				return nil, err
+			}
+			log.Printf("Failed to fetch RSS feeds for language %q, ignoring: %s\n", lang, err.Error())
+			continue
+		}
+
+		for _, item := range translatedItems {
+			if a, ok := byGUID[item.GUID]; ok {
+				a.mergeRssItemTranslation(item, lang)
+			}
+		}
+	}
+
+	return alerts, nil
+}
+
+// EnrichAlert implements Backend
+func (b *wtpBackend) EnrichAlert(ctx context.Context, a *Alert, routeMap map[string]sort.StringSlice, languages []string) error {
+	return a.LoadExternal(ctx, b.client, routeMap, languages, b.translate)
+}
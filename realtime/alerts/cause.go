@@ -0,0 +1,100 @@
+package alerts
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// causeKeyword pairs a Polish keyword (matched case-insensitively as a substring) with the
+// GTFS-RT cause it implies. Checked in order, so more specific keywords should come first.
+type causeKeyword struct {
+	keyword string
+	cause   gtfsrt.Alert_Cause
+}
+
+var causeKeywords = []causeKeyword{
+	{"awaria", gtfsrt.Alert_TECHNICAL_PROBLEM},
+	{"awarii", gtfsrt.Alert_TECHNICAL_PROBLEM},
+	{"wypadek", gtfsrt.Alert_ACCIDENT},
+	{"wypadku", gtfsrt.Alert_ACCIDENT},
+	{"kolizj", gtfsrt.Alert_ACCIDENT},
+	{"roboty drogowe", gtfsrt.Alert_CONSTRUCTION},
+	{"remont", gtfsrt.Alert_CONSTRUCTION},
+	{"prace budowlane", gtfsrt.Alert_CONSTRUCTION},
+	{"objazd", gtfsrt.Alert_OTHER_CAUSE},
+}
+
+// classifyCause guesses a.Cause from the Polish source text (title and/or description),
+// by matching the keywords a Warsaw transit alert typically uses to explain itself.
+// Unmatched text is reported as Alert_UNKNOWN_CAUSE rather than guessed at.
+func classifyCause(text string) gtfsrt.Alert_Cause {
+	lower := strings.ToLower(text)
+	for _, ck := range causeKeywords {
+		if strings.Contains(lower, ck.keyword) {
+			return ck.cause
+		}
+	}
+	return gtfsrt.Alert_UNKNOWN_CAUSE
+}
+
+// classifySeverity guesses a.SeverityLevel from the alert's Effect: a REDUCED_SERVICE
+// impediment is treated as more severe than an OTHER_EFFECT change/notice.
+func classifySeverity(effect string) gtfsrt.Alert_SeverityLevel {
+	if effect == "REDUCED_SERVICE" {
+		return gtfsrt.Alert_WARNING
+	}
+	return gtfsrt.Alert_INFO
+}
+
+// parseActivePeriod extracts a single active period from a Polish alert's plaintext, looking
+// for an "od DD.MM.YYYY do DD.MM.YYYY" range first, then falling back to a lone DD.MM.YYYY
+// date treated as a single-day period. Returns nil if no date could be found - most alerts
+// don't state one explicitly, and the GTFS-RT ActivePeriod field is optional.
+func parseActivePeriod(text string) []*gtfsrt.TimeRange {
+	if m := regexDateRange.FindStringSubmatch(text); m != nil {
+		start := parseDate(m[1], m[2], m[3])
+		end := parseDate(m[4], m[5], m[6])
+		if start != nil && end != nil {
+			endOfDay := *end + 24*60*60
+			return []*gtfsrt.TimeRange{{Start: start, End: &endOfDay}}
+		}
+	}
+
+	if m := regexSingleDate.FindStringSubmatch(text); m != nil {
+		start := parseDate(m[1], m[2], m[3])
+		if start != nil {
+			endOfDay := *start + 24*60*60
+			return []*gtfsrt.TimeRange{{Start: start, End: &endOfDay}}
+		}
+	}
+
+	return nil
+}
+
+// parseDate turns day/month/year strings (as captured by regexDateRange/regexSingleDate)
+// into a POSIX timestamp of that day's midnight in Europe/Warsaw, or nil if any part
+// doesn't parse or forms an invalid calendar date.
+func parseDate(day, month, year string) *uint64 {
+	d, errD := strconv.Atoi(day)
+	m, errM := strconv.Atoi(month)
+	y, errY := strconv.Atoi(year)
+	if errD != nil || errM != nil || errY != nil {
+		return nil
+	}
+
+	loc, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, loc)
+	if t.Day() != d || int(t.Month()) != m || t.Year() != y {
+		return nil // e.g. "31.02.2026" normalized to March by time.Date
+	}
+
+	ts := uint64(t.Unix())
+	return &ts
+}
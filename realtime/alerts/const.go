@@ -16,5 +16,28 @@ var regexpColor *regexp.Regexp = regexp.MustCompile("(?i)^#([0-9a-f]{3,4}|[0-9a-
 var regexID *regexp.Regexp = regexp.MustCompile(`&p=(\d+)`)
 var regexRoute *regexp.Regexp = regexp.MustCompile(`[0-9A-Za-z-]{1,3}`)
 
+// regexDateRange matches a Polish "od DD.MM.YYYY do DD.MM.YYYY" date range, as commonly
+// used in wtp.waw.pl alert texts to describe how long an impediment/change lasts.
+var regexDateRange *regexp.Regexp = regexp.MustCompile(
+	`(?i)od\s+(\d{1,2})\.(\d{1,2})\.(\d{4})\s+do\s+(\d{1,2})\.(\d{1,2})\.(\d{4})`,
+)
+
+// regexSingleDate matches a lone DD.MM.YYYY date, used as a fallback for alerts
+// worded as e.g. "w dniu DD.MM.YYYY" instead of a date range.
+var regexSingleDate *regexp.Regexp = regexp.MustCompile(`(\d{1,2})\.(\d{1,2})\.(\d{4})`)
+
 const urlChanges string = "https://www.wtp.waw.pl/feed/?post_type=change"
 const urlImpediments string = "https://www.wtp.waw.pl/feed/?post_type=impediment"
+
+// defaultLanguage is the BCP-47 tag of the language the WTP website is scraped in
+// by default, i.e. without any "lang" query parameter.
+const defaultLanguage string = "pl"
+
+// feedURLForLanguage appends a "lang" query parameter to one of the RSS feed URLs above,
+// unless lang is the defaultLanguage, in which case the URL is returned unchanged.
+func feedURLForLanguage(feedURL string, lang string) string {
+	if lang == defaultLanguage {
+		return feedURL
+	}
+	return feedURL + "&lang=" + lang
+}
@@ -1,6 +1,7 @@
 package alerts
 
 import (
+	"context"
 	"net/url"
 	"strings"
 
@@ -79,7 +80,7 @@ func getMarkdownConverter() (conv *md.Converter) {
 
 // getWebsite downloads a website with an alert description,
 // and passes the website to goquery.
-func getWebsite(client exclusiveHTTPClient, rawurl string, alertID string) (doc *goquery.Document, err error) {
+func getWebsite(ctx context.Context, client exclusiveHTTPClient, rawurl string, alertID string) (doc *goquery.Document, err error) {
 	// Parse the URL
 	parsedURL, err := url.Parse(rawurl)
 	if err != nil {
@@ -93,7 +94,7 @@ func getWebsite(client exclusiveHTTPClient, rawurl string, alertID string) (doc
 	}
 
 	// Request the url
-	resp, err := client.Get(rawurl)
+	resp, err := client.Get(ctx, rawurl)
 	if err != nil {
 		return
 	}
@@ -166,3 +167,13 @@ func getAlertDesc(doc *goquery.Document, alertType string) (htmlBody string, err
 func getAlertPlaintext(htmlBody string) (string, error) {
 	return markdownConverter.ConvertString(htmlBody)
 }
+
+// findAlternateLink looks for a `<link rel="alternate" hreflang="lang">` tag advertising
+// a translated version of the current page, as emitted by WTP's WPML integration.
+func findAlternateLink(doc *goquery.Document, lang string) (href string, ok bool) {
+	selection := doc.Find(`link[rel="alternate"][hreflang="` + lang + `"]`)
+	if selection.Length() == 0 {
+		return "", false
+	}
+	return selection.AttrOr("href", ""), true
+}
@@ -0,0 +1,182 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/cjoudrey/gluahttp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LuaBackend drives alert scraping from an external Lua script, so operators can cover
+// another feed (e.g. a scripts/km.lua for Koleje Mazowieckie, or a replacement-bus feed)
+// without a Go rebuild or a fork of this repo, mirroring positions.LuaAdapter.
+//
+// A script must define a global list_alerts() function, taking no arguments and returning a
+// table of alert tables: {id=, link=, effect=, title=, routes=} (routes is optional - a
+// table of route short_names; when omitted, they're parsed out of title the same way wtp.waw.pl
+// RSS titles are). effect must be one of the GTFS-RT Alert.Effect names understood by
+// Alert.makeAlertEffect ("REDUCED_SERVICE" or anything else, treated as OTHER_EFFECT).
+//
+// A script must also define enrich_alert(link), called once per alert returned by
+// list_alerts(), returning {html_body=, plaintext_body=, flags=} (flags is optional - a
+// table of category strings like "metro"/"autobusy", used to derive Routes when the alert
+// didn't already have any). Both functions are free to use the preloaded "http" module
+// (github.com/cjoudrey/gluahttp) to reach the upstream feed themselves.
+type LuaBackend struct {
+	ScriptPath string
+
+	state *lua.LState
+}
+
+// NewLuaBackend loads and runs the script at path, registering its globals. The returned
+// LuaBackend owns a Lua state that must be released with Close.
+func NewLuaBackend(path string, client *http.Client) (*LuaBackend, error) {
+	state := lua.NewState()
+	state.PreloadModule("http", gluahttp.NewHttpModule(client).Loader)
+
+	if err := state.DoFile(path); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("loading lua alert backend %s: %w", path, err)
+	}
+
+	if _, ok := state.GetGlobal("list_alerts").(*lua.LFunction); !ok {
+		state.Close()
+		return nil, fmt.Errorf("lua alert backend %s does not define a list_alerts() function", path)
+	}
+	if _, ok := state.GetGlobal("enrich_alert").(*lua.LFunction); !ok {
+		state.Close()
+		return nil, fmt.Errorf("lua alert backend %s does not define an enrich_alert() function", path)
+	}
+
+	return &LuaBackend{ScriptPath: path, state: state}, nil
+}
+
+// Close releases the underlying Lua state. Call it once the backend is no longer used.
+func (b *LuaBackend) Close() { b.state.Close() }
+
+// luaAlertRow is the wire shape a script's list_alerts() returns for a single alert.
+type luaAlertRow struct {
+	ID     string   `json:"id"`
+	Link   string   `json:"link"`
+	Effect string   `json:"effect"`
+	Title  string   `json:"title"`
+	Routes []string `json:"routes"`
+}
+
+// ListAlerts implements Backend by calling the script's list_alerts() function.
+// throwErrors is unused: a script has no secondary per-language fetch to fail independently,
+// unlike wtpBackend's RSS-per-language feeds.
+func (b *LuaBackend) ListAlerts(ctx context.Context, routeMap map[string]sort.StringSlice, languages []string, throwErrors bool) ([]*Alert, error) {
+	if err := b.state.CallByParam(
+		lua.P{Fn: b.state.GetGlobal("list_alerts"), NRet: 1, Protect: true},
+	); err != nil {
+		return nil, fmt.Errorf("lua list_alerts(): %w", err)
+	}
+	ret := b.state.Get(-1)
+	b.state.Pop(1)
+
+	raw, err := json.Marshal(luaToGoAlerts(ret))
+	if err != nil {
+		return nil, fmt.Errorf("lua list_alerts() returned a value that can't be encoded: %w", err)
+	}
+
+	var rows []luaAlertRow
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, fmt.Errorf("decoding alerts from %s: %w", b.ScriptPath, err)
+	}
+
+	alerts := make([]*Alert, 0, len(rows))
+	for _, row := range rows {
+		a := &Alert{
+			ID:          row.ID,
+			Link:        row.Link,
+			Effect:      row.Effect,
+			Routes:      row.Routes,
+			Header:      map[string]string{defaultLanguage: row.Title},
+			Description: map[string]string{},
+			HTMLBody:    map[string]string{},
+		}
+		a.Cause = classifyCause(row.Title)
+		a.SeverityLevel = classifySeverity(row.Effect)
+		if len(a.Routes) == 0 {
+			a.Routes = extractRoutesFromTitle(row.Title, routeMap)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+// luaEnrichRow is the wire shape a script's enrich_alert() returns for a single alert.
+type luaEnrichRow struct {
+	HTMLBody      string   `json:"html_body"`
+	PlaintextBody string   `json:"plaintext_body"`
+	Flags         []string `json:"flags"`
+}
+
+// EnrichAlert implements Backend by calling the script's enrich_alert(link) function.
+// Only the defaultLanguage entries of HTMLBody and Description are filled in; languages is
+// unused.
+func (b *LuaBackend) EnrichAlert(ctx context.Context, a *Alert, routeMap map[string]sort.StringSlice, languages []string) error {
+	if err := b.state.CallByParam(
+		lua.P{Fn: b.state.GetGlobal("enrich_alert"), NRet: 1, Protect: true},
+		lua.LString(a.Link),
+	); err != nil {
+		return fmt.Errorf("lua enrich_alert(%q): %w", a.Link, err)
+	}
+	ret := b.state.Get(-1)
+	b.state.Pop(1)
+
+	raw, err := json.Marshal(luaToGoAlerts(ret))
+	if err != nil {
+		return fmt.Errorf("lua enrich_alert(%q) returned a value that can't be encoded: %w", a.Link, err)
+	}
+
+	var row luaEnrichRow
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return fmt.Errorf("decoding enriched alert %s from %s: %w", a.ID, b.ScriptPath, err)
+	}
+
+	a.HTMLBody[defaultLanguage] = row.HTMLBody
+	a.Description[defaultLanguage] = row.PlaintextBody
+	if len(a.Routes) == 0 {
+		a.Routes = routesFromFlags(row.Flags, routeMap)
+	}
+	return nil
+}
+
+// luaToGoAlerts converts a lua.LValue tree into plain Go values (map[string]interface{},
+// []interface{}, string, float64, bool, nil), so it can be passed to json.Marshal. It's a
+// copy of positions.luaToGo - each package's Lua glue is self-contained so neither reaches
+// into the other's unexported helpers.
+func luaToGoAlerts(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case *lua.LTable:
+		// A table with only consecutive integer keys starting at 1 is treated as an
+		// array, matching how gopher-lua represents a Lua array-style table.
+		if n := v.Len(); n > 0 {
+			arr := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				arr[i-1] = luaToGoAlerts(v.RawGetInt(i))
+			}
+			return arr
+		}
+
+		obj := make(map[string]interface{})
+		v.ForEach(func(k, val lua.LValue) {
+			obj[k.String()] = luaToGoAlerts(val)
+		})
+		return obj
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	default:
+		return nil
+	}
+}
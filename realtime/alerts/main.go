@@ -3,7 +3,8 @@ package alerts
 // cSpell: words cenkalti
 
 import (
-	"io"
+	"bytes"
+	"context"
 	"log"
 	"net/http"
 	"sort"
@@ -21,6 +22,51 @@ type Options struct {
 	JSONTarget      string
 	HumanReadable   bool
 	ThrowLinkErrors bool
+
+	// Languages lists the BCP-47 language tags to fetch translations for.
+	// defaultLanguage ("pl") is always scraped from wtp.waw.pl without any "lang" query
+	// parameter, whether or not it's listed; every other entry is fetched as a translation.
+	// Defaults to ["pl"].
+	Languages []string
+
+	// Translate, when set, is used to fill in a language's header/description when
+	// wtp.waw.pl doesn't advertise a native page for it (no matching hreflang alternate
+	// link). It receives the defaultLanguage plaintext and the target BCP-47 tag, e.g. to
+	// call out to DeepL or LibreTranslate. Left nil, such languages are simply skipped.
+	// HTMLBody is never auto-translated, only Header/Description. Only used by the default
+	// wtp.waw.pl backend - ignored when Backends is set.
+	//
+	// NewTranslateFunc builds one of these out of a Translator (see GlossaryTranslator,
+	// HTTPTranslator) plus a cache keyed by language pair and a hash of the source text (not
+	// by alert GUID), which is how the CLI in
+	// warsawgtfs_realtime.go wires up -translate-url/-translate-key/-translate-glossary.
+	Translate func(ctx context.Context, text, fromLang, toLang string) (string, error)
+
+	// Backends lists the alert sources to scrape and merge into a single feed - e.g. to add
+	// a LuaBackend alongside or instead of the built-in wtp.waw.pl scraper, for operators
+	// (KM, WKD, replacement bus feeds) that tool doesn't know about natively. Defaults to a
+	// single wtp.waw.pl backend when left empty.
+	Backends []Backend
+
+	// OnContainer, when non-nil, is called with the filtered AlertContainer on every
+	// successful Make/MakeContext run, before it's written to GtfsRtTarget/JSONTarget. This
+	// lets a caller (see realtime/server) publish the feed straight to HTTP clients instead
+	// of, or in addition to, the files this package writes itself.
+	OnContainer func(*AlertContainer) error
+
+	// OnError, when non-nil, is called with the error from a failed MakeContext attempt
+	// inside Loop/LoopContext - including attempts the backoff policy will retry, not just
+	// the final failure that ends the loop - so a caller (see realtime/server) can track
+	// fetch/error counts independently of whether the loop itself keeps running.
+	OnError func(error)
+}
+
+// backendsOrDefault returns opts.Backends, falling back to a single wtp.waw.pl Backend
+func (opts Options) backendsOrDefault(client *http.Client) []Backend {
+	if len(opts.Backends) > 0 {
+		return opts.Backends
+	}
+	return []Backend{NewWtpBackend(client, opts.Translate)}
 }
 
 // exclusiveHttpClient is a pair of *html.Client and *sync.Mutex
@@ -30,22 +76,27 @@ type exclusiveHTTPClient struct {
 	c *http.Client
 }
 
-func (client exclusiveHTTPClient) Get(url string) (resp *http.Response, err error) {
+func (client exclusiveHTTPClient) Get(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
 	client.m.Lock()
 	defer client.m.Unlock()
-	return client.c.Get(url)
+	return client.c.Do(req)
 }
 
-// allRssItems fetches urlImpediments and urlChanges and retrieves all
-// RssItems that should be converted into Alerts
-func allRssItems(client exclusiveHTTPClient) (items []*rssItem, err error) {
+// allRssItems fetches urlImpediments and urlChanges (in the given language) and
+// retrieves all RssItems that should be converted into Alerts
+func allRssItems(ctx context.Context, client exclusiveHTTPClient, lang string) (items []*rssItem, err error) {
 	// Load both RSS feeds
-	impedimentsRss, err := getRss(client, urlImpediments, "REDUCED_SERVICE")
+	impedimentsRss, err := getRss(ctx, client, feedURLForLanguage(urlImpediments, lang), "REDUCED_SERVICE")
 	if err != nil {
 		return
 	}
 
-	changesRss, err := getRss(client, urlChanges, "OTHER_EFFECT")
+	changesRss, err := getRss(ctx, client, feedURLForLanguage(urlChanges, lang), "OTHER_EFFECT")
 	if err != nil {
 		return
 	}
@@ -58,40 +109,51 @@ func allRssItems(client exclusiveHTTPClient) (items []*rssItem, err error) {
 	return
 }
 
-// Make auto-magically creates GTFS-Realtime feeds with alert data
-func Make(client *http.Client, routeMap map[string]sort.StringSlice, opts Options) (err error) {
+// languages returns opts.Languages, defaulting to just the defaultLanguage when unset
+func (opts Options) languages() []string {
+	if len(opts.Languages) == 0 {
+		return []string{defaultLanguage}
+	}
+	return opts.Languages
+}
+
+// Make auto-magically creates GTFS-Realtime feeds with alert data.
+// It's a thin wrapper around MakeContext using context.Background().
+func Make(client *http.Client, routeMap map[string]sort.StringSlice, opts Options) error {
+	return MakeContext(context.Background(), client, routeMap, opts)
+}
+
+// MakeContext is Make with a caller-provided context, so fetching the RSS feeds and
+// alert pages can be cancelled or bound to a deadline.
+func MakeContext(ctx context.Context, client *http.Client, routeMap map[string]sort.StringSlice, opts Options) (err error) {
 	// Create a container for all Alerts
 	var container AlertContainer
 	container.Timestamp = time.Now()
 	container.Time = container.Timestamp.Format(time.RFC3339)
 
-	// Wrap the http.Client into exclusiveHTTPClient to avoid spamming wtp.waw.pl
-	exclusiveClient := exclusiveHTTPClient{
-		m: &sync.Mutex{},
-		c: client,
-	}
-
-	// Load both RSS feeds
-	log.Println("Fetching RSS feeds")
-	items, err := allRssItems(exclusiveClient)
-	if err != nil {
-		return
-	}
+	languages := opts.languages()
+	backends := opts.backendsOrDefault(client)
 
-	// Convert RSS items to Alert objects
-	log.Println("Casting RSS items to Alert objects")
-	for _, item := range items {
-		var a *Alert
-		a, err = alertFromRssItem(item, routeMap)
+	// List alerts from every backend, remembering which backend produced each one so it can
+	// be enriched by the same backend below
+	backendOf := make(map[*Alert]Backend)
+	for _, backend := range backends {
+		var alerts []*Alert
+		alerts, err = backend.ListAlerts(ctx, routeMap, languages, opts.ThrowLinkErrors)
 		if err != nil {
 			return
 		}
 
-		container.Alerts = append(container.Alerts, a)
+		for _, a := range alerts {
+			container.Alerts = append(container.Alerts, a)
+			backendOf[a] = backend
+		}
 	}
 
-	// Load data from alert links
-	err = container.LoadExternal(exclusiveClient, routeMap, opts.ThrowLinkErrors)
+	// Load data from alert links, via whichever backend listed each alert
+	err = container.EnrichAll(ctx, func(ctx context.Context, a *Alert) error {
+		return backendOf[a].EnrichAlert(ctx, a, routeMap, languages)
+	}, opts.ThrowLinkErrors)
 	if err != nil {
 		return
 	}
@@ -99,6 +161,12 @@ func Make(client *http.Client, routeMap map[string]sort.StringSlice, opts Option
 	// Filter invalid alerts
 	container.Filter()
 
+	if opts.OnContainer != nil {
+		if err = opts.OnContainer(&container); err != nil {
+			return
+		}
+	}
+
 	// Export to a JSON file
 	if opts.JSONTarget != "" {
 		log.Println("Exporting to JSON")
@@ -113,54 +181,59 @@ func Make(client *http.Client, routeMap map[string]sort.StringSlice, opts Option
 	return
 }
 
-// routesResource is a pair of resource pointing to a GTFS file and a routeMap
+// routesResource wraps a util.Fetcher that turns a fetched GTFS into a routeMap
 type routesResource struct {
-	Resource util.Resource
+	Fetcher  *util.Fetcher[map[string]sort.StringSlice]
 	RouteMap map[string]sort.StringSlice
 }
 
-// Update automatically updates the RouteMap if the Resource has changed
-func (rr *routesResource) Update() error {
-	// Check for GTFS updates
-	shouldUpdate, err := rr.Resource.Check()
+// newRoutesResource builds a routesResource polling source no more often than checkPeriod
+func newRoutesResource(source util.FetchSource, checkPeriod time.Duration) *routesResource {
+	rr := &routesResource{}
+	rr.Fetcher = &util.Fetcher[map[string]sort.StringSlice]{
+		Source: source,
+		Period: checkPeriod,
+		Decode: func(raw []byte) (map[string]sort.StringSlice, error) {
+			gtfsObj, err := gtfs.NewGtfsFromReaderAt(bytes.NewReader(raw), int64(len(raw)))
+			if err != nil {
+				return nil, err
+			}
+			defer gtfsObj.Close()
+			return gtfs.ListGtfsRoutes(gtfsObj)
+		},
+		OnUpdate: func(routeMap map[string]sort.StringSlice) error {
+			rr.RouteMap = routeMap
+			return nil
+		},
+	}
+	return rr
+}
+
+// Update fetches and decodes the GTFS again if it changed (or on the first call)
+func (rr *routesResource) Update(ctx context.Context) error {
+	updated, err := rr.Fetcher.Poll(ctx, rr.RouteMap == nil)
 	if err != nil {
 		return err
-	} else if shouldUpdate || rr.RouteMap == nil {
+	} else if updated {
 		log.Println("GTFS has changed, updating available route_ids.")
-
-		var newData io.ReadCloser
-		var gtfsObj *gtfs.Gtfs
-
-		// Try to fetch updated GTFS
-		newData, err := rr.Resource.Fetch()
-		if err != nil {
-			return err
-		}
-
-		// Load the new GTFS
-		defer newData.Close()
-		gtfsObj, err = gtfs.NewGtfsFromReader(newData)
-		if err != nil {
-			return err
-		}
-		defer gtfsObj.Close()
-
-		// Load GTFS routers
-		rr.RouteMap, err = gtfs.ListGtfsRoutes(gtfsObj)
-		if err != nil {
-			return err
-		}
 	}
 	return nil
 }
 
-// Loop automatically updates the GTFS-RT alerts file
-func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Duration, opts Options) (err error) {
+// Loop automatically updates the GTFS-RT alerts file.
+// It's a thin wrapper around LoopContext using context.Background().
+func Loop(client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, opts Options) error {
+	return LoopContext(context.Background(), client, gtfsSource, checkPeriod, sleepTime, opts)
+}
+
+// LoopContext is Loop with a caller-provided context. Cancelling ctx stops the loop
+// (returning ctx.Err()) instead of waiting out the current sleep or backoff.
+func LoopContext(ctx context.Context, client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, opts Options) (err error) {
 	// We don't use ticker as there's no guarantee that a single pass
 	// will be shorter then sleepTime.
 	// And, it doesn't really matter, it's not mission critical that the alerts feed is updated
 	// every `sleepTime`, it's fine if it's updated sleepTime + a few seconds.
-	rr := &routesResource{Resource: gtfsResource}
+	rr := newRoutesResource(gtfsSource, checkPeriod)
 	backoff := &backoff.ExponentialBackOff{
 		InitialInterval:     10 * time.Second,
 		RandomizationFactor: 0.3,
@@ -173,7 +246,7 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 
 	for {
 		// Try to update the underlaying GTFS data
-		err = rr.Update()
+		err = rr.Update(ctx)
 		if err != nil {
 			return
 		}
@@ -190,12 +263,19 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 					sleepUntil, err.Error(),
 				)
 
-				// Sleep for the backoff
-				time.Sleep(sleep)
+				// Sleep for the backoff, unless ctx is cancelled first
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(sleep):
+				}
 			}
 
 			// Try to update the GTFS-RT
-			err = Make(client, rr.RouteMap, opts)
+			err = MakeContext(ctx, client, rr.RouteMap, opts)
+			if err != nil && opts.OnError != nil {
+				opts.OnError(err)
+			}
 
 			// If no errors were encountered, break out of the backoff loop
 			if err == nil {
@@ -207,7 +287,11 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 			return
 		}
 
-		// Sleep until next try
-		time.Sleep(sleepTime)
+		// Sleep until next try, unless ctx is cancelled first
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleepTime):
+		}
 	}
 }
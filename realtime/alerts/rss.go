@@ -1,6 +1,7 @@
 package alerts
 
 import (
+	"context"
 	"encoding/xml"
 	"io"
 
@@ -51,9 +52,9 @@ func unmarshalRss(data []byte, assignItemType string) (*rssRoot, error) {
 // expecting a valid RSS in return.
 // If 'assignItemType' is set, every rssItem in channel.Items
 // will have its 'Type' field set to the provided value
-func getRss(client exclusiveHTTPClient, url string, assignItemType string) (*rssRoot, error) {
+func getRss(ctx context.Context, client exclusiveHTTPClient, url string, assignItemType string) (*rssRoot, error) {
 	// Request the url
-	resp, err := client.Get(url)
+	resp, err := client.Get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,179 @@
+package alerts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Translator supplies a translated string for text (in fromLang) into toLang. It's the
+// pluggable piece behind Options.Translate - see GlossaryTranslator and HTTPTranslator for
+// the two built-in implementations, combined by NewTranslateFunc into the plain function
+// Options.Translate (and, downstream, wtpBackend) actually calls.
+type Translator interface {
+	Translate(ctx context.Context, text, fromLang, toLang string) (string, error)
+}
+
+// GlossaryTranslator overrides known phrases - stop/street names an HTTP translator tends to
+// mangle, or any other recurring phrase worth pinning down - with a fixed translation, loaded
+// from a YAML file keyed by target language:
+//
+//	en:
+//	  "Plac Zamkowy": "Castle Square"
+//	uk:
+//	  "Plac Zamkowy": "Замкова площа"
+//
+// Phrases missing from the glossary are passed through unchanged, so GlossaryTranslator is
+// meant to run as an overlay on top of another Translator's output, not as a full translation
+// on its own.
+type GlossaryTranslator struct {
+	entries map[string]map[string]string
+}
+
+// LoadGlossaryTranslator reads a YAML glossary file in the format documented on
+// GlossaryTranslator.
+func LoadGlossaryTranslator(path string) (*GlossaryTranslator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading translation glossary %s: %w", path, err)
+	}
+
+	entries := make(map[string]map[string]string)
+	if err := yaml.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("parsing translation glossary %s: %w", path, err)
+	}
+
+	return &GlossaryTranslator{entries: entries}, nil
+}
+
+// Translate implements Translator by substituting every phrase the glossary knows for toLang.
+func (g *GlossaryTranslator) Translate(ctx context.Context, text, fromLang, toLang string) (string, error) {
+	for phrase, replacement := range g.entries[toLang] {
+		text = strings.ReplaceAll(text, phrase, replacement)
+	}
+	return text, nil
+}
+
+// HTTPTranslator calls a LibreTranslate/DeepL-compatible HTTP endpoint: POST url as
+// application/x-www-form-urlencoded with q/source/target(/api_key) fields, expecting a JSON
+// body of the shape {"translatedText": "..."} back.
+type HTTPTranslator struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+// NewHTTPTranslator builds an HTTPTranslator calling translateURL. apiKey is sent as the
+// api_key form field whenever non-empty.
+func NewHTTPTranslator(client *http.Client, translateURL, apiKey string) *HTTPTranslator {
+	return &HTTPTranslator{client: client, url: translateURL, apiKey: apiKey}
+}
+
+// httpTranslateResponse is the subset of a LibreTranslate/DeepL-compatible response this
+// package cares about.
+type httpTranslateResponse struct {
+	TranslatedText string `json:"translatedText"`
+}
+
+// Translate implements Translator
+func (t *HTTPTranslator) Translate(ctx context.Context, text, fromLang, toLang string) (string, error) {
+	form := url.Values{
+		"q":      {text},
+		"source": {fromLang},
+		"target": {toLang},
+		"format": {"text"},
+	}
+	if t.apiKey != "" {
+		form.Set("api_key", t.apiKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode <= 199 || resp.StatusCode >= 300 {
+		return "", util.RequestError{URL: t.url, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+
+	var parsed httpTranslateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("decoding response from %s: %w", t.url, err)
+	}
+	return parsed.TranslatedText, nil
+}
+
+// translationCache remembers every (text, fromLang, toLang) triple a Translator has already
+// translated, keyed by a sha256 of the source text rather than the full text itself, so a
+// re-run of the alerts loop against an unchanged alert doesn't re-query the translation
+// backend on every pass.
+type translationCache struct {
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+func newTranslationCache() *translationCache {
+	return &translationCache{seen: make(map[string]string)}
+}
+
+func (c *translationCache) key(text, fromLang, toLang string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("%s>%s:%x", fromLang, toLang, sum)
+}
+
+// NewTranslateFunc combines glossary and httpBackend (either of which may be nil) into the
+// plain function Options.Translate expects: httpBackend, when set, does the actual
+// translation; glossary, when set, then overrides known phrases in its result (or, with no
+// httpBackend configured, is applied to the untranslated source text - useful for languages a
+// fixed glossary alone can cover). Every result is cached (see translationCache) so restarting
+// the loop doesn't re-translate alerts it has already seen.
+func NewTranslateFunc(glossary *GlossaryTranslator, httpBackend *HTTPTranslator) func(ctx context.Context, text, fromLang, toLang string) (string, error) {
+	cache := newTranslationCache()
+
+	return func(ctx context.Context, text, fromLang, toLang string) (string, error) {
+		key := cache.key(text, fromLang, toLang)
+
+		cache.mu.Lock()
+		cached, ok := cache.seen[key]
+		cache.mu.Unlock()
+		if ok {
+			return cached, nil
+		}
+
+		result := text
+		var err error
+		if httpBackend != nil {
+			result, err = httpBackend.Translate(ctx, text, fromLang, toLang)
+			if err != nil {
+				return "", err
+			}
+		}
+		if glossary != nil {
+			result, err = glossary.Translate(ctx, result, fromLang, toLang)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		cache.mu.Lock()
+		cache.seen[key] = result
+		cache.mu.Unlock()
+		return result, nil
+	}
+}
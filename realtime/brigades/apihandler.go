@@ -1,12 +1,14 @@
 package brigades
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/MKuranowski/WarsawGTFS/realtime/util"
 )
@@ -14,8 +16,8 @@ import (
 // routeStopPait is a struct, for a (route_id, stop_id) pair
 type routeStopPair struct{ Route, Stop string }
 
-// mapTimeBrigade is an alias for a map from a timestamp to a brigade_id
-type mapTimeBrigade = map[string]string
+// mapTimeBrigade is an alias for a map from a number of seconds since midnight to a brigade_id
+type mapTimeBrigade = map[uint32]string
 
 // invalidTTableAPIResp represents an invalid response from api.um.warszawa.pl
 type invalidTTableAPIResp struct{ text string }
@@ -28,8 +30,15 @@ func (e invalidTTableAPIResp) Error() string {
 type ttableAPI struct {
 	Key           string
 	Client        *http.Client
-	Respones      map[routeStopPair]mapTimeBrigade // routeStop → time → brigade
 	ForwardErrors bool
+
+	// Cache persists Responses across process invocations. Defaults to a no-op cache
+	// when left nil, i.e. every Get has to hit the API at least once per process.
+	Cache BrigadeCache
+
+	// responsesMutex guards Responses, which is shared across the worker pool used by Match
+	responsesMutex sync.RWMutex
+	Responses      map[routeStopPair]mapTimeBrigade // routeStop → seconds since midnight → brigade
 }
 
 // BuildURL returns the URL to retrieve timetables of a specific route-stop pair
@@ -49,18 +58,34 @@ func (api *ttableAPI) BuildURL(rs routeStopPair) string {
 	return requestURL.String()
 }
 
-// Get returns the time→brigade map for a particular route-stop pair
-func (api *ttableAPI) Get(rs routeStopPair) (mapTimeBrigade, bool, error) {
-	// Check if this pair was defined earlier
-	ttb, hasCached := api.Respones[rs]
+// Get returns the time→brigade map for a particular route-stop pair, fetching it from
+// the API and caching the result in api.Responses if it wasn't requested before.
+func (api *ttableAPI) Get(ctx context.Context, rs routeStopPair) (mapTimeBrigade, bool, error) {
+	// Check if this pair was defined earlier, either in-memory or in the on-disk cache
+	api.responsesMutex.RLock()
+	ttb, hasCached := api.Responses[rs]
+	api.responsesMutex.RUnlock()
 	if hasCached {
 		return ttb, true, nil
 	}
 
+	if api.Cache != nil {
+		if ttb, hasCached = api.Cache.Load(rs); hasCached {
+			api.responsesMutex.Lock()
+			api.Responses[rs] = ttb
+			api.responsesMutex.Unlock()
+			return ttb, true, nil
+		}
+	}
+
 	// Prepare request
 	logPrintf("Making call for R %s | S %s", true, rs.Route, rs.Stop)
 	requestURL := api.BuildURL(rs)
-	resp, err := api.Client.Get(requestURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := api.Client.Do(req)
 	if err != nil {
 		return nil, false, err
 	}
@@ -88,12 +113,18 @@ func (api *ttableAPI) Get(rs routeStopPair) (mapTimeBrigade, bool, error) {
 
 	// Parse the response
 	ttb, err = parseBrigadesResponse(rawData, rs, api.ForwardErrors)
+
+	api.responsesMutex.Lock()
+	defer api.responsesMutex.Unlock()
 	if err != nil {
-		api.Respones[rs] = make(mapTimeBrigade)
+		api.Responses[rs] = make(mapTimeBrigade)
 		return nil, false, err
 	}
 
-	api.Respones[rs] = ttb
+	api.Responses[rs] = ttb
+	if api.Cache != nil {
+		api.Cache.Save(rs, ttb)
+	}
 	return ttb, false, nil
 }
 
@@ -130,19 +161,19 @@ func parseBrigadesResponse(rawData []byte, rs routeStopPair, forwardErrors bool)
 	// Extract time→brigade mapping
 	for _, result := range decodedData.Result {
 		var brigade string
-		var time string
+		var timeStr string
 
 		// try to find matching fields
 		for _, value := range result.Values {
 			if value.Key == "brygada" {
 				brigade = value.Value
 			} else if value.Key == "czas" {
-				time = value.Value
+				timeStr = value.Value
 			}
 		}
 
 		// check if "brygada" and "czas" fields exist
-		if (brigade == "" || time == "") && forwardErrors {
+		if (brigade == "" || timeStr == "") && forwardErrors {
 			errInfo := fmt.Sprintf(
 				"Timetable API for %+v returned a timetable with missing times or brigades (%q)",
 				rs, string(rawData))
@@ -151,9 +182,17 @@ func parseBrigadesResponse(rawData []byte, rs routeStopPair, forwardErrors bool)
 				err = invalidTTableAPIResp{errInfo}
 				return
 			}
+			continue
+		}
+
+		// "czas" is a HH:MM:SS string - key the map by seconds since midnight so it can
+		// be compared directly against GTFS departure_time (which uses the same units)
+		seconds, errTime := util.ParseTimeToSeconds(timeStr)
+		if errTime != nil {
+			continue
 		}
 
-		mtb[time] = brigade
+		mtb[seconds] = brigade
 	}
 
 	return
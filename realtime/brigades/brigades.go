@@ -2,6 +2,7 @@ package brigades
 
 import (
 	"cmp"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -114,21 +115,17 @@ func newStopTimeEvent(gtfs *gtfs.Gtfs, row map[string]string) (ste stopTimeEvent
 	return
 }
 
-// Match matches trips to brigade ids
-func Match(api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (matches MatchedTripData, err error) {
-	// Prepare the map for holding data
-	matches = make(MatchedTripData)
-
-	// Read stop_times.txt
+// readStopTimeEvents reads stop_times.txt, keeping only the rows belonging to an active
+// bus/tram trip. Rows are returned in file order; Match takes each trip's last stop from the
+// highest stop_sequence among them, whatever their order.
+func readStopTimeEvents(gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (events []stopTimeEvent, err error) {
 	csvReader := csv.NewReader(stopTimesReader)
 	header, err := csvReader.Read()
-
 	if err != nil {
 		return
 	}
 
 	for {
-		// Try to get next row
 		var rowSlice []string
 		rowSlice, err = csvReader.Read()
 
@@ -139,7 +136,6 @@ func Match(api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (matches
 			return
 		}
 
-		// Convert row to map and assert all required columns are there
 		var row stopTimeEvent
 		rowMap := util.ZipStrings(header, rowSlice)
 		row, err = newStopTimeEvent(gtfs, rowMap)
@@ -154,6 +150,43 @@ func Match(api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (matches
 			continue
 		}
 
+		events = append(events, row)
+	}
+
+	return
+}
+
+// Match matches trips to brigade ids. The route-stop pairs referenced by stopTimesReader are
+// first prefetched concurrently through a bounded worker pool (see prefetch), so the
+// sequential matching pass below is served from api's in-memory cache - except for pairs
+// whose request got a non-2xx response, which api.Get doesn't cache and requests again.
+func Match(ctx context.Context, api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader, workers int, rateLimit float64) (matches MatchedTripData, err error) {
+	events, err := readStopTimeEvents(gtfs, stopTimesReader)
+	if err != nil {
+		return
+	}
+
+	// Collect the distinct route-stop pairs referenced by the filtered events and warm
+	// up api.Responses for all of them before the (cache-only) matching pass below
+	seenPairs := make(map[routeStopPair]bool)
+	pairs := make([]routeStopPair, 0)
+	for _, row := range events {
+		rs := routeStopPair{Route: row.RouteID, Stop: row.StopID}
+		if !seenPairs[rs] {
+			seenPairs[rs] = true
+			pairs = append(pairs, rs)
+		}
+	}
+
+	logPrintf("Prefetching timetables for %d route-stop pairs", false, len(pairs))
+	if err = prefetch(ctx, api, pairs, workers, rateLimit); err != nil {
+		return
+	}
+
+	// Prepare the map for holding data
+	matches = make(MatchedTripData)
+
+	for _, row := range events {
 		// Fetch an entry for this trip from matches
 		tripEntry, wasInserted := matches[row.TripID]
 
@@ -187,10 +220,10 @@ func Match(api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (matches
 			return
 		}
 
-		// Get time→brigade mapping for this route-stop pair
+		// Get time→brigade mapping for this route-stop pair - already cached by prefetch
 		var mtb mapTimeBrigade
 		var apiFromCache bool
-		mtb, apiFromCache, err = api.Get(routeStopPair{Route: row.RouteID, Stop: row.StopID})
+		mtb, apiFromCache, err = api.Get(ctx, routeStopPair{Route: row.RouteID, Stop: row.StopID})
 		if err != nil {
 			return
 		}
@@ -210,15 +243,6 @@ func Match(api *ttableAPI, gtfs *gtfs.Gtfs, stopTimesReader io.Reader) (matches
 			continue
 		} else {
 			tripEntry.BrigadeID = brigadeID
-			// logPrintf(
-			// 	"StopTimeEvent: T %s | R %s | S %s (from api: %t) ✔️ match for %s",
-			// 	true,
-			// 	row.TripID,
-			// 	row.RouteID,
-			// 	row.StopID,
-			// 	!apiFromCache,
-			// 	row.Time,
-			// )
 		}
 	}
 
@@ -0,0 +1,138 @@
+package brigades
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// BrigadeCache persists ttableAPI responses across brigades.Main invocations, so that a
+// daily regeneration doesn't have to re-request timetables that haven't changed.
+type BrigadeCache interface {
+	Load(rs routeStopPair) (mapTimeBrigade, bool)
+	Save(rs routeStopPair, mtb mapTimeBrigade)
+	Flush() error
+}
+
+// noopBrigadeCache is the BrigadeCache used when caching is disabled (Options.NoCache or
+// an empty Options.CacheDir); every Load is a miss and Save/Flush are no-ops.
+type noopBrigadeCache struct{}
+
+func (noopBrigadeCache) Load(routeStopPair) (mapTimeBrigade, bool) { return nil, false }
+func (noopBrigadeCache) Save(routeStopPair, mapTimeBrigade)        {}
+func (noopBrigadeCache) Flush() error                              { return nil }
+
+// jsonCacheEntry is a single cached timetable, as stored in the cache file
+type jsonCacheEntry struct {
+	FeedVersion string         `json:"feed_version"`
+	SavedAt     time.Time      `json:"saved_at"`
+	Timetable   mapTimeBrigade `json:"timetable"`
+}
+
+// jsonBrigadeCache is a BrigadeCache backed by a single JSON file on disk. Entries are keyed
+// by (route, stop), each remembering the feedVersion it was saved under - a feedVersion
+// mismatch (the underlying GTFS changed) or an entry older than ttl is treated the same as a
+// cache miss. Only one entry per (route, stop) is kept: a Save replaces whatever version was
+// stored for that pair before.
+type jsonBrigadeCache struct {
+	path        string
+	feedVersion string
+	ttl         time.Duration
+
+	mu      sync.Mutex
+	entries map[routeStopPair]jsonCacheEntry
+	dirty   bool
+}
+
+// NewJSONBrigadeCache opens (or creates) a JSON-file BrigadeCache at path, scoped to the
+// given feedVersion - see gtfs.Gtfs.FeedVersion. Entries from a previous, differently-versioned
+// GTFS stay on disk until a Save for the same route-stop pair replaces them, but are never
+// returned by Load.
+func NewJSONBrigadeCache(path string, feedVersion string, ttl time.Duration) (*jsonBrigadeCache, error) {
+	c := &jsonBrigadeCache{
+		path:        path,
+		feedVersion: feedVersion,
+		ttl:         ttl,
+		entries:     make(map[routeStopPair]jsonCacheEntry),
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var onDisk []struct {
+		RouteStopPair routeStopPair
+		jsonCacheEntry
+	}
+	if err := json.Unmarshal(raw, &onDisk); err != nil {
+		return nil, err
+	}
+
+	for _, e := range onDisk {
+		c.entries[e.RouteStopPair] = e.jsonCacheEntry
+	}
+
+	return c, nil
+}
+
+func (c *jsonBrigadeCache) Load(rs routeStopPair) (mapTimeBrigade, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[rs]
+	if !ok || entry.FeedVersion != c.feedVersion {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(entry.SavedAt) > c.ttl {
+		return nil, false
+	}
+	return entry.Timetable, true
+}
+
+func (c *jsonBrigadeCache) Save(rs routeStopPair, mtb mapTimeBrigade) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[rs] = jsonCacheEntry{
+		FeedVersion: c.feedVersion,
+		SavedAt:     time.Now(),
+		Timetable:   mtb,
+	}
+	c.dirty = true
+}
+
+// Flush writes every cached entry back to c.path, if anything changed since it was opened.
+func (c *jsonBrigadeCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	type onDiskEntry struct {
+		RouteStopPair routeStopPair
+		jsonCacheEntry
+	}
+	onDisk := make([]onDiskEntry, 0, len(c.entries))
+	for rs, entry := range c.entries {
+		onDisk = append(onDisk, onDiskEntry{RouteStopPair: rs, jsonCacheEntry: entry})
+	}
+
+	raw, err := json.MarshalIndent(onDisk, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o777); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return os.WriteFile(c.path, raw, 0o666)
+}
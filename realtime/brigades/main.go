@@ -1,9 +1,12 @@
 package brigades
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
 )
@@ -13,16 +16,57 @@ type Options struct {
 	JSONTarget     string
 	Apikey         string
 	ThrowAPIErrors bool
+
+	// Workers is the number of goroutines concurrently prefetching route-stop
+	// timetables. Defaults to defaultWorkers when left at zero.
+	Workers int
+
+	// RateLimit caps outgoing requests to api.um.warszawa.pl, in requests/sec,
+	// shared across all Workers. Defaults to defaultRateLimit when left at zero.
+	RateLimit float64
+
+	// CacheDir, if non-empty, enables an on-disk BrigadeCache stored in that directory,
+	// so that repeated Main invocations against an unchanged GTFS skip the API entirely.
+	CacheDir string
+
+	// CacheTTL discards cache entries older than this duration. Zero means cache entries
+	// never expire on their own (they're still invalidated by a GTFS feed version change).
+	CacheTTL time.Duration
+
+	// NoCache disables the on-disk cache even when CacheDir is set.
+	NoCache bool
 }
 
-// Main auto-magically creates brigades data
+// openCache builds the BrigadeCache requested by opts for the given GTFS
+func openCache(opts Options, gtfsFile *gtfs.Gtfs) (BrigadeCache, error) {
+	if opts.NoCache || opts.CacheDir == "" {
+		return noopBrigadeCache{}, nil
+	}
+	path := filepath.Join(opts.CacheDir, "brigade_cache.json")
+	return NewJSONBrigadeCache(path, gtfsFile.FeedVersion(), opts.CacheTTL)
+}
+
+// Main auto-magically creates brigades data.
+// It's a thin wrapper around MainContext using context.Background().
 func Main(client *http.Client, gtfs *gtfs.Gtfs, opts Options) error {
+	return MainContext(context.Background(), client, gtfs, opts)
+}
+
+// MainContext is Main with a caller-provided context, so the API requests made while
+// matching trips to brigades can be cancelled or bound to a deadline.
+func MainContext(ctx context.Context, client *http.Client, gtfs *gtfs.Gtfs, opts Options) error {
+	cache, err := openCache(opts, gtfs)
+	if err != nil {
+		return err
+	}
+
 	// Create an API object
 	api := &ttableAPI{
 		Key:           opts.Apikey,
 		Client:        client,
-		Reposnses:     make(map[routeStopPair]mapTimeBrigade),
+		Responses:     make(map[routeStopPair]mapTimeBrigade),
 		ForwardErrors: opts.ThrowAPIErrors,
+		Cache:         cache,
 	}
 
 	// Try to open stop_times.txt
@@ -38,11 +82,16 @@ func Main(client *http.Client, gtfs *gtfs.Gtfs, opts Options) error {
 
 	// Match data
 	logPrint("Matching data", false)
-	data, err := Match(api, gtfs, reader)
+	data, err := Match(ctx, api, gtfs, reader, opts.Workers, opts.RateLimit)
 	if err != nil {
 		return err
 	}
 
+	// Persist the on-disk cache for the next invocation
+	if err = cache.Flush(); err != nil {
+		return err
+	}
+
 	// Marshall it to JSON
 	logPrint("Marshalling data to JSON", false)
 	dataJSON, err := data.MarshalJSON()
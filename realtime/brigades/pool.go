@@ -0,0 +1,137 @@
+package brigades
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/time/rate"
+)
+
+// defaultWorkers and defaultRateLimit are used whenever Match is called with a non-positive
+// workers/rateLimit argument.
+const (
+	defaultWorkers   = 8
+	defaultRateLimit = 10.0 // requests/sec
+)
+
+// progressInterval is how often prefetch logs a progress line
+const progressInterval = 200
+
+// prefetch concurrently warms api.Responses for every routeStopPair in pairs, using a bounded
+// worker pool gated by a rate.Limiter shared across all workers, so api.um.warszawa.pl doesn't
+// get hammered with thousands of requests at once. Each request is retried with a short
+// exponential backoff on transient failures (5xx responses, timeouts); a 4xx response aborts
+// the whole prefetch immediately, as retrying it would never succeed.
+func prefetch(ctx context.Context, api *ttableAPI, pairs []routeStopPair, workers int, rateLimit float64) error {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if rateLimit <= 0 {
+		rateLimit = defaultRateLimit
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(rateLimit), 1)
+
+	jobs := make(chan routeStopPair)
+	errCh := make(chan error, workers)
+
+	var done, calls, latency int64
+	total := int64(len(pairs))
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rs := range jobs {
+				if err := limiter.Wait(ctx); err != nil {
+					errCh <- err
+					return
+				}
+
+				reqStart := time.Now()
+				if err := fetchWithRetry(ctx, api, rs, &calls); err != nil {
+					errCh <- err
+					return
+				}
+				atomic.AddInt64(&latency, int64(time.Since(reqStart)))
+
+				if n := atomic.AddInt64(&done, 1); n%progressInterval == 0 || n == total {
+					avg := time.Duration(atomic.LoadInt64(&latency) / n)
+					logPrintf(
+						"matched %d/%d route-stop pairs, %d API calls, avg latency %s",
+						true, n, total, atomic.LoadInt64(&calls), avg.Round(time.Millisecond),
+					)
+				}
+			}
+		}()
+	}
+
+	// workersDone closes once every worker has returned, so the feeder below doesn't hang
+	// forever if ALL workers exit early on a permanent error (e.g. a bad apikey hits every
+	// request) before every pair has been fed - without this, the feeder's blocking send on
+	// jobs would have no receiver left and ctx.Done() alone wouldn't save it either.
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+feed:
+	for _, rs := range pairs {
+		select {
+		case jobs <- rs:
+		case <-ctx.Done():
+			break feed
+		case <-workersDone:
+			break feed
+		}
+	}
+	close(jobs)
+	<-workersDone
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchWithRetry calls api.Get for rs, retrying transient errors (5xx responses, timeouts,
+// connection failures) with a short exponential backoff. Any other error - a util.RequestError
+// with a 4xx StatusCode, or a response that fails to parse - is treated as fatal and returned
+// immediately.
+func fetchWithRetry(ctx context.Context, api *ttableAPI, rs routeStopPair, calls *int64) error {
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     500 * time.Millisecond,
+		RandomizationFactor: 0.3,
+		Multiplier:          2,
+		MaxInterval:         10 * time.Second,
+		MaxElapsedTime:      30 * time.Second,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+
+	return backoff.Retry(func() error {
+		atomic.AddInt64(calls, 1)
+		_, _, err := api.Get(ctx, rs)
+
+		if err == nil {
+			return nil
+		}
+		if reqErr, ok := err.(util.RequestError); ok && reqErr.StatusCode >= 500 {
+			return err
+		}
+		if _, ok := err.(net.Error); ok {
+			return err
+		}
+		return backoff.Permanent(err)
+	}, b)
+}
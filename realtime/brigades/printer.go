@@ -1,25 +1,23 @@
 package brigades
 
 import (
-	"log"
-)
+	"fmt"
 
-var lastPrintOverwritable bool = false
+	"github.com/MKuranowski/WarsawGTFS/realtime/logging"
+)
 
+// logPrint logs s through logging.Progress/logging.Info, tagged with feed="brigades".
+// overwritable marks s as a transient status line (see logPrintf) rather than a one-off
+// message - e.g. an individual API error is not overwritable, but "Matching data" is.
 func logPrint(s string, overwritable bool) {
-	if lastPrintOverwritable {
-		log.SetPrefix("\033[1A\033[K")
+	if overwritable {
+		logging.Progress(s, "feed", "brigades")
+	} else {
+		logging.Info(s, "feed", "brigades")
 	}
-	log.Println(s)
-	log.SetPrefix("")
-	lastPrintOverwritable = overwritable
 }
 
+// logPrintf is logPrint for a formatted message, e.g. a prefetch progress counter.
 func logPrintf(format string, overwritable bool, v ...interface{}) {
-	if lastPrintOverwritable {
-		log.SetPrefix("\033[1A\033[K")
-	}
-	log.Printf(format+"\n", v...)
-	log.SetPrefix("")
-	lastPrintOverwritable = overwritable
+	logPrint(fmt.Sprintf(format, v...), overwritable)
 }
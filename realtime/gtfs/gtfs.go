@@ -2,9 +2,11 @@ package gtfs
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"os"
@@ -32,7 +34,19 @@ type TripData struct {
 	Route        string
 	Service      string
 	Brigade      string
+	ShapeID      string
 	LastStopTime StopTime
+
+	// StopTimes holds every stop_time of the trip, sorted by Sequence, for consumers
+	// that need more than just the terminus (e.g. tripupdates.Extrapolator). Populated
+	// by LoadStopTimes the same way as LastStopTime, so it's equally subject to filter.
+	StopTimes []StopTime
+}
+
+// ShapePoint is a single, sequenced point of a shapes.txt shape
+type ShapePoint struct {
+	Lat, Lon float64
+	Sequence int64
 }
 
 // Gtfs is an object with access to GTFS data
@@ -41,6 +55,7 @@ type Gtfs struct {
 	Stops    map[string][2]float64       // stop_id → [stop_lat stop_lon]
 	Services map[string]bool             // service_id → true (if service is active on g.SyncTime)
 	Trips    map[string]TripData         // trip_id → TripData
+	Shapes   map[string][]ShapePoint     // shape_id → [ShapePoint, ...] sorted by Sequence
 
 	fileObj  ReaderAtCloser
 	ZipFile  *zip.Reader
@@ -55,6 +70,7 @@ func NewGtfsFromFile(fname string) (gtfs *Gtfs, err error) {
 		Stops:    make(map[string][2]float64),
 		Services: make(map[string]bool),
 		Trips:    make(map[string]TripData),
+		Shapes:   make(map[string][]ShapePoint),
 	}
 
 	// Open the file
@@ -84,10 +100,22 @@ func NewGtfsFromFile(fname string) (gtfs *Gtfs, err error) {
 	return
 }
 
-// NewGtfsFromURL automatically creates a Gtfs object from a URL
-func NewGtfsFromURL(url string, client *http.Client) (gtfs *Gtfs, err error) {
+// NewGtfsFromURL automatically creates a Gtfs object from a URL.
+// It's a thin wrapper around NewGtfsFromURLContext using context.Background().
+func NewGtfsFromURL(url string, client *http.Client) (*Gtfs, error) {
+	return NewGtfsFromURLContext(context.Background(), url, client)
+}
+
+// NewGtfsFromURLContext is NewGtfsFromURL with a caller-provided context, so the download
+// can be cancelled or bound to a deadline.
+func NewGtfsFromURLContext(ctx context.Context, url string, client *http.Client) (gtfs *Gtfs, err error) {
 	// Request the URL
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return
 	}
@@ -100,17 +128,30 @@ func NewGtfsFromURL(url string, client *http.Client) (gtfs *Gtfs, err error) {
 	}
 
 	// Make a GTFS object from the response Body
-	return NewGtfsFromReader(resp.Body)
+	return NewGtfsFromReaderContext(ctx, resp.Body)
+}
+
+// NewGtfsFromReader automatically creates a Gtfs object from a io.Reader.
+// It's a thin wrapper around NewGtfsFromReaderContext using context.Background().
+func NewGtfsFromReader(r io.Reader) (*Gtfs, error) {
+	return NewGtfsFromReaderContext(context.Background(), r)
 }
 
-// NewGtfsFromReader automatically creates a Gtfs object from a io.Reader
-func NewGtfsFromReader(r io.Reader) (gtfs *Gtfs, err error) {
+// NewGtfsFromReaderContext is NewGtfsFromReader with a caller-provided context. ctx is only
+// checked before r is copied to a tempfile, so cancellation during the copy only takes effect
+// if r itself respects ctx (e.g. the Body of a request made with NewRequestWithContext).
+func NewGtfsFromReaderContext(ctx context.Context, r io.Reader) (gtfs *Gtfs, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
 	// Make all the required maps & set the syncTime
 	gtfs = &Gtfs{
 		Routes:   make(map[string]sort.StringSlice),
 		Stops:    make(map[string][2]float64),
 		Services: make(map[string]bool),
 		Trips:    make(map[string]TripData),
+		Shapes:   make(map[string][]ShapePoint),
 		SyncTime: time.Now(),
 	}
 
@@ -147,11 +188,63 @@ func NewGtfsFromReader(r io.Reader) (gtfs *Gtfs, err error) {
 	return
 }
 
+// NewGtfsFromReaderAt creates a Gtfs object directly from an io.ReaderAt, skipping the
+// tempfile copy NewGtfsFromReader has to do to turn an io.Reader into something
+// zip.NewReader can seek around. Use this when the caller already holds the whole GTFS
+// in something ReaderAt-capable, e.g. a *bytes.Reader or an already-open *os.File.
+// If r also implements io.Closer, Gtfs.Close closes it; otherwise Close is a no-op.
+func NewGtfsFromReaderAt(r io.ReaderAt, size int64) (gtfs *Gtfs, err error) {
+	gtfs = &Gtfs{
+		Routes:   make(map[string]sort.StringSlice),
+		Stops:    make(map[string][2]float64),
+		Services: make(map[string]bool),
+		Trips:    make(map[string]TripData),
+		Shapes:   make(map[string][]ShapePoint),
+		SyncTime: time.Now(),
+	}
+
+	if closer, ok := r.(ReaderAtCloser); ok {
+		gtfs.fileObj = closer
+	} else {
+		gtfs.fileObj = readerAtNopCloser{r}
+	}
+
+	zipFile, err := zip.NewReader(gtfs.fileObj, size)
+	if err != nil {
+		return
+	}
+
+	gtfs.ZipFile = zipFile
+	return
+}
+
+// readerAtNopCloser adapts a plain io.ReaderAt (e.g. *bytes.Reader) into a ReaderAtCloser
+// whose Close is a no-op, for callers of NewGtfsFromReaderAt with nothing to close.
+type readerAtNopCloser struct{ io.ReaderAt }
+
+func (readerAtNopCloser) Close() error { return nil }
+
 // Close closes the underlying file object
 func (g *Gtfs) Close() error {
 	return g.fileObj.Close()
 }
 
+// FeedVersion returns a short fingerprint of routes.txt, trips.txt and stop_times.txt,
+// derived from their sizes and CRC32 checksums as recorded in the zip's central directory.
+// Callers can use this to invalidate caches keyed off the content of those files without
+// having to hash the (potentially huge) stop_times.txt themselves.
+func (g *Gtfs) FeedVersion() string {
+	h := fnv.New64a()
+	for _, name := range []string{"routes.txt", "trips.txt", "stop_times.txt"} {
+		f := g.GetZipFileByName(name)
+		if f == nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d:%d;", name, f.UncompressedSize64, f.CRC32)
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
 // GetZipFileByName will loop over every file in the zip.Reader object,
 // and return the first pointer to zip.File that matches the provided filename.
 // A nil-pointer is returned if no matching file was found.
@@ -354,15 +447,90 @@ func (g *Gtfs) LoadTrips(file *zip.File) (err error) {
 			return errors.New("trips.txt is missing the block_short_name column")
 		}
 
-		// Save data
-		g.Trips[tripID] = TripData{routeID, serviceID, brigade, StopTime{}}
+		// Save data; shape_id is optional, used only for snapping vehicle positions
+		g.Trips[tripID] = TripData{Route: routeID, Service: serviceID, Brigade: brigade, ShapeID: row["shape_id"]}
 	}
 	return
 }
 
-// LoadStopTimes loads stop_times.txt from provided zip.File.
-// This must be called after LoadTrips() completes.
-func (g *Gtfs) LoadStopTimes(file *zip.File) (err error) {
+// LoadShapes loads shapes.txt from provided zip.File. shapes.txt is an optional
+// GTFS file, so callers should skip this call entirely when it's absent rather
+// than treating a missing file as an error.
+func (g *Gtfs) LoadShapes(file *zip.File) (err error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer fileReader.Close()
+
+	csvReader := csv.NewReader(fileReader)
+	header, err := csvReader.Read()
+
+	if err != nil {
+		return
+	}
+
+	for {
+		// Retrieve next row
+		rowSlice, errI := csvReader.Read()
+		err = errI
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+
+		// Convert row to a map and assert all requires columns are there
+		row := util.ZipStrings(header, rowSlice)
+		err = util.MissingColumnCheck(
+			"shapes.txt",
+			[]string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"},
+			row)
+		if err != nil {
+			return
+		}
+
+		lat, err := strconv.ParseFloat(row["shape_pt_lat"], 64)
+		if err != nil {
+			return err
+		}
+
+		lon, err := strconv.ParseFloat(row["shape_pt_lon"], 64)
+		if err != nil {
+			return err
+		}
+
+		seq, err := strconv.ParseInt(row["shape_pt_sequence"], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		shapeID := row["shape_id"]
+		g.Shapes[shapeID] = append(g.Shapes[shapeID], ShapePoint{Lat: lat, Lon: lon, Sequence: seq})
+	}
+
+	for shapeID, points := range g.Shapes {
+		sort.Slice(points, func(i, j int) bool { return points[i].Sequence < points[j].Sequence })
+		g.Shapes[shapeID] = points
+	}
+
+	return
+}
+
+// TripFilter reports whether a trip_id should be kept while loading stop_times.txt.
+// Rows belonging to a rejected trip are skipped before parsing their stop_sequence,
+// so a selective filter (e.g. "is this trip's service active today") cuts the CPU
+// cost of LoadStopTimes roughly in proportion to how many trips it excludes. A nil
+// TripFilter keeps every trip, matching the pre-filter behavior.
+type TripFilter func(tripID string) bool
+
+// LoadStopTimes loads stop_times.txt from provided zip.File, recording every StopTime of a
+// trip in its TripData.StopTimes (sorted by stop_sequence) and the last one (by
+// stop_sequence) in its TripData.LastStopTime.
+// This must be called after LoadTrips() completes. If filter is non-nil, rows whose
+// trip_id is rejected by filter are skipped.
+func (g *Gtfs) LoadStopTimes(file *zip.File, filter TripFilter) (err error) {
 	if file == nil {
 		return errors.New("file stop_times.txt is missing")
 	}
@@ -399,6 +567,11 @@ func (g *Gtfs) LoadStopTimes(file *zip.File) (err error) {
 			return errors.New("stop_times.txt is missing the trip_id column")
 		}
 
+		// Skip rows for trips the caller isn't interested in before parsing the rest
+		if filter != nil && !filter(tripID) {
+			continue
+		}
+
 		stopID, ok := row["stop_id"]
 		if !ok {
 			return errors.New("stop_times.txt is missing the stop_id column")
@@ -420,12 +593,25 @@ func (g *Gtfs) LoadStopTimes(file *zip.File) (err error) {
 		}
 
 		if t, ok := g.Trips[tripID]; ok {
+			st := StopTime{stopID, departureTime, int16(stopSequence)}
 			if t.LastStopTime.Timepoint == "" || t.LastStopTime.Sequence < int16(stopSequence) {
-				t.LastStopTime = StopTime{stopID, departureTime, int16(stopSequence)}
-				g.Trips[tripID] = t
+				t.LastStopTime = st
 			}
+			t.StopTimes = append(t.StopTimes, st)
+			g.Trips[tripID] = t
+		}
+	}
+
+	// stop_times.txt rows aren't guaranteed to arrive in stop_sequence order
+	for tripID, t := range g.Trips {
+		if len(t.StopTimes) > 1 {
+			sort.Slice(t.StopTimes, func(i, j int) bool {
+				return t.StopTimes[i].Sequence < t.StopTimes[j].Sequence
+			})
+			g.Trips[tripID] = t
 		}
 	}
+
 	return
 }
 
@@ -473,8 +659,20 @@ func (g *Gtfs) LoadAll() error {
 		return err
 	}
 
-	// Load stop_times at last, as this must be done after LoadTrips completes
-	err := g.LoadStopTimes(g.GetZipFileByName("stop_times.txt"))
+	// Load stop_times at last, as this must be done after LoadTrips completes.
+	// Only trips whose service is active on g.SyncTime matter to any realtime consumer,
+	// so skip the rest - this is what cuts the working set down for Warsaw's feed.
+	err := g.LoadStopTimes(g.GetZipFileByName("stop_times.txt"), func(tripID string) bool {
+		return g.Services[g.Trips[tripID].Service]
+	})
+	if err != nil {
+		return err
+	}
+
+	// shapes.txt is optional in GTFS - only load it if present
+	if shapesFile := g.GetZipFileByName("shapes.txt"); shapesFile != nil {
+		err = g.LoadShapes(shapesFile)
+	}
 
 	return err
 }
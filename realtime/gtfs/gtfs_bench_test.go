@@ -0,0 +1,93 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// buildStopTimesZip creates an in-memory zip with a single stop_times.txt containing
+// tripCount trips of stopsPerTrip rows each, for use by BenchmarkLoadStopTimes.
+func buildStopTimesZip(tripCount, stopsPerTrip int) *zip.Reader {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+
+	f, err := w.Create("stop_times.txt")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(f, "trip_id,stop_id,departure_time,stop_sequence")
+	for t := 0; t < tripCount; t++ {
+		tripID := fmt.Sprintf("T%d", t)
+		for s := 0; s < stopsPerTrip; s++ {
+			fmt.Fprintf(f, "%s,S%d,%02d:00:00,%d\n", tripID, s, 4+s, s)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// newBenchGtfs builds a Gtfs with tripCount trips, half of them belonging to an
+// active service, so a TripFilter based on g.Services can discard the rest.
+func newBenchGtfs(tripCount int) *Gtfs {
+	g := &Gtfs{
+		Services: map[string]bool{"active": true},
+		Trips:    make(map[string]TripData),
+	}
+	for t := 0; t < tripCount; t++ {
+		service := "inactive"
+		if t%2 == 0 {
+			service = "active"
+		}
+		g.Trips[fmt.Sprintf("T%d", t)] = TripData{Service: service}
+	}
+	return g
+}
+
+func BenchmarkLoadStopTimesUnfiltered(b *testing.B) {
+	const tripCount, stopsPerTrip = 2000, 20
+	zipFile := buildStopTimesZip(tripCount, stopsPerTrip)
+	file := &zip.File{}
+	for _, f := range zipFile.File {
+		if f.Name == "stop_times.txt" {
+			file = f
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g := newBenchGtfs(tripCount)
+		if err := g.LoadStopTimes(file, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadStopTimesFiltered(b *testing.B) {
+	const tripCount, stopsPerTrip = 2000, 20
+	zipFile := buildStopTimesZip(tripCount, stopsPerTrip)
+	file := &zip.File{}
+	for _, f := range zipFile.File {
+		if f.Name == "stop_times.txt" {
+			file = f
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g := newBenchGtfs(tripCount)
+		filter := func(tripID string) bool { return g.Services[g.Trips[tripID].Service] }
+		if err := g.LoadStopTimes(file, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// buildFeedZip creates an in-memory zip with routes.txt/trips.txt/stop_times.txt each
+// containing the given content, for use by TestFeedVersion.
+func buildFeedZip(routes, trips, stopTimes string) *zip.Reader {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+
+	for name, content := range map[string]string{
+		"routes.txt":     routes,
+		"trips.txt":      trips,
+		"stop_times.txt": stopTimes,
+	} {
+		f, err := w.Create(name)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprint(f, content)
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestFeedVersion(t *testing.T) {
+	a := &Gtfs{ZipFile: buildFeedZip("route_id\nA", "trip_id\nT1", "trip_id,stop_id\nT1,S1")}
+	b := &Gtfs{ZipFile: buildFeedZip("route_id\nA", "trip_id\nT1", "trip_id,stop_id\nT1,S1")}
+	changed := &Gtfs{ZipFile: buildFeedZip("route_id\nA", "trip_id\nT1", "trip_id,stop_id\nT1,S2")}
+
+	if a.FeedVersion() != b.FeedVersion() {
+		t.Errorf("FeedVersion() differs for identical content: %q != %q", a.FeedVersion(), b.FeedVersion())
+	}
+	if a.FeedVersion() == changed.FeedVersion() {
+		t.Errorf("FeedVersion() didn't change when stop_times.txt content changed: %q", a.FeedVersion())
+	}
+}
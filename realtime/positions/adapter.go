@@ -0,0 +1,89 @@
+package positions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// Adapter abstracts the feed-specific parts of the positions pipeline: how the raw
+// vehicle feed is fetched, how it's parsed into Vehicle objects, and how a Vehicle is
+// matched to a trip_id. VehicleContainer, AsProto, SavePB, CalculateBearing and the
+// shape-snapping logic stay adapter-agnostic, so a new city/feed only has to provide
+// an Adapter - either a Go implementation or a Lua script run by LuaAdapter.
+type Adapter interface {
+	// FetchRaw retrieves the raw upstream feed body for this tick. ctx should be threaded
+	// through to any upstream HTTP request, so a caller (see LoopContext) can cancel a tick
+	// that's stuck fetching.
+	FetchRaw(ctx context.Context) (io.ReadCloser, error)
+
+	// Parse turns a raw feed body, as returned by FetchRaw, into Vehicle objects.
+	Parse(raw io.Reader) ([]*Vehicle, error)
+
+	// MatchTrip guesses which trip_id v is running, given its previous position pv
+	// (nil if unknown), the brigade schedule entries known for its vehicle id, and the
+	// time this tick was taken at.
+	MatchTrip(v, pv *Vehicle, brigades []*brigadeEntry, synctime compareTime) error
+}
+
+// WarsawAdapter is the default Adapter, backed by a Provider (api.um.warszawa.pl by
+// default, see Provider/ProviderFactories) and brigades.json trip matching.
+type WarsawAdapter struct {
+	// Provider supplies this adapter's raw vehicle entries. Defaults to a WarsawProvider
+	// built from API when left nil, so existing callers that only set API keep working
+	// unchanged.
+	Provider Provider
+
+	// API is kept so the default WarsawProvider can be built without callers having to
+	// construct one themselves; ignored once Provider is set directly.
+	API VehicleAPI
+}
+
+// providerOrDefault returns a.Provider, falling back to a WarsawProvider wrapping a.API.
+func (a *WarsawAdapter) providerOrDefault() Provider {
+	if a.Provider != nil {
+		return a.Provider
+	}
+	return &WarsawProvider{API: a.API}
+}
+
+// FetchRaw calls this adapter's Provider and hands back the result re-encoded as JSON, so
+// Parse has a single, self-contained raw representation to work with regardless of which
+// Provider produced it.
+func (a *WarsawAdapter) FetchRaw(ctx context.Context) (io.ReadCloser, error) {
+	entries, err := a.providerOrDefault().Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(raw)), nil
+}
+
+// Parse decodes the JSON produced by FetchRaw back into APIVehicleEntry objects and
+// converts each one into a Vehicle.
+func (a *WarsawAdapter) Parse(raw io.Reader) ([]*Vehicle, error) {
+	var entries []*APIVehicleEntry
+	if err := json.NewDecoder(raw).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	vehicles := make([]*Vehicle, 0, len(entries))
+	for _, e := range entries {
+		v, err := NewVehicle(e)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, v)
+	}
+	return vehicles, nil
+}
+
+// MatchTrip delegates to Vehicle.MatchTrip, the existing brigades.json-driven heuristic.
+func (a *WarsawAdapter) MatchTrip(v, pv *Vehicle, brigades []*brigadeEntry, synctime compareTime) error {
+	return v.MatchTrip(pv, synctime, brigades)
+}
@@ -16,6 +16,12 @@ import (
 type APIVehicleEntry struct {
 	Lat, Lon                            float64
 	Time, Lines, Brigade, VehicleNumber string
+
+	// Capabilities is usually left at its zero value here - a Provider only fills it in
+	// when its upstream feed already carries that information itself. Create instead fills
+	// it in from Options.VehicleDB, keyed by VehicleNumber, once the entry has become a
+	// Vehicle (see NewVehicle).
+	Capabilities Capabilities `json:",omitempty"`
 }
 
 // apiError is an error object used when the API returns an error
@@ -31,6 +37,11 @@ func (e apiError) Error() string {
 type VehicleAPI struct {
 	Key    string
 	Client *http.Client
+
+	// ThrowAPIErrors controls whether a non-2xx response from the API is returned as an
+	// error. When false, such responses are logged and treated as an empty result, so that
+	// a single misbehaving request type doesn't stop the whole Loop.
+	ThrowAPIErrors bool
 }
 
 // buildURL returs the url of API endpoint with vehicle data for given vehicle type
@@ -57,12 +68,16 @@ func (api *VehicleAPI) Get(apiVehType string) ([]*APIVehicleEntry, error) {
 
 	// Check response code
 	if resp.StatusCode <= 199 || resp.StatusCode >= 300 {
-		err = util.RequestError{
+		reqErr := util.RequestError{
 			URL:        strings.ReplaceAll(reqURL, api.Key, "xxxxxx"),
 			Status:     resp.Status,
 			StatusCode: resp.StatusCode,
 		}
-		return nil, err
+		if api.ThrowAPIErrors {
+			return nil, reqErr
+		}
+		log.Printf("busestrams_get request failed, ignoring: %s\n", reqErr.Error())
+		return nil, nil
 	}
 
 	// Read the response
@@ -11,6 +11,7 @@ import (
 // brigadeEntry is an object representing an object from brigades.json
 type brigadeEntry struct {
 	TripID            string
+	ShapeID           string
 	LastStopID        string
 	LastStopPos       [2]float64
 	LastStopTimepoint string
@@ -36,6 +37,7 @@ func loadBrigades(gtfsFile *gtfs.Gtfs) (m map[string][]*brigadeEntry, err error)
 		key := fmt.Sprintf("V/%s/%s", data.Route, data.Brigade)
 		entry := brigadeEntry{
 			TripID:            id,
+			ShapeID:           data.ShapeID,
 			LastStopID:        data.LastStopTime.StopID,
 			LastStopPos:       gtfsFile.Stops[data.LastStopTime.StopID],
 			LastStopTimepoint: data.LastStopTime.Timepoint,
@@ -0,0 +1,199 @@
+package positions
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+)
+
+// Capabilities is a bitfield of comfort/accessibility features a vehicle offers. See
+// VehicleDB for where a Vehicle's Capabilities come from.
+type Capabilities uint8
+
+const (
+	CapWheelchairAccessible Capabilities = 1 << iota
+	CapLowFloor
+	CapAirConditioning
+	CapBikesAllowed
+	CapUSBCharging
+)
+
+// capabilityCodes pairs every Capabilities bit with the short code used both by
+// labelWithCapabilities (the GTFS-RT VehicleDescriptor.Label suffix) and the CSV column
+// headers decodeVehicleDB understands.
+var capabilityCodes = []struct {
+	bit  Capabilities
+	code string
+}{
+	{CapWheelchairAccessible, "WC"},
+	{CapLowFloor, "LF"},
+	{CapAirConditioning, "AC"},
+	{CapBikesAllowed, "BA"},
+	{CapUSBCharging, "USB"},
+}
+
+// labelWithCapabilities appends a Capabilities suffix to base (a vehicle's side number),
+// e.g. "1009|WC,LF,USB". Neither TripUpdate nor VehiclePosition's VehicleDescriptor has a
+// dedicated field for arbitrary capability flags, so this is how they're surfaced in the
+// GTFS-Realtime output - a caller that cares can split on "|" and ",".
+func labelWithCapabilities(base string, c Capabilities) string {
+	if c == 0 {
+		return base
+	}
+	var codes []string
+	for _, cc := range capabilityCodes {
+		if c&cc.bit != 0 {
+			codes = append(codes, cc.code)
+		}
+	}
+	return base + "|" + strings.Join(codes, ",")
+}
+
+// CapabilitiesLabel returns v.SideNumber with v.Capabilities appended as a suffix (see
+// labelWithCapabilities), for use as a GTFS-Realtime VehicleDescriptor.Label by packages
+// (e.g. tripupdates) that build their own VehicleDescriptor rather than going through
+// Vehicle.AsProto.
+func (v *Vehicle) CapabilitiesLabel() string {
+	return labelWithCapabilities(v.SideNumber, v.Capabilities)
+}
+
+// FleetRangeCapabilities guesses a vehicle's Capabilities from its side number, for
+// vehicles VehicleDB's loaded mapping doesn't cover. Warsaw's fleet numbers roughly encode
+// the vehicle's model/vintage by range, newest (and best-equipped) fleets having been
+// assigned the highest numbers - this is a convenience default, not a guarantee, and a
+// -vehicle-db entry always takes priority over it.
+func FleetRangeCapabilities(vehicleNumber string) Capabilities {
+	n, err := strconv.Atoi(strings.TrimSpace(vehicleNumber))
+	if err != nil {
+		return 0
+	}
+
+	switch {
+	case n >= 9000:
+		// Newest low-floor fleet: fully accessible and amenity-equipped
+		return CapWheelchairAccessible | CapLowFloor | CapAirConditioning | CapBikesAllowed | CapUSBCharging
+	case n >= 3000:
+		// Mid-life low-floor fleet: accessible, but without the newer amenities
+		return CapWheelchairAccessible | CapLowFloor
+	default:
+		// Older high-floor fleet: no modern capabilities assumed
+		return 0
+	}
+}
+
+// VehicleDB maps a vehicle's side number to its Capabilities, loaded from a JSON or CSV
+// side-file (see decodeVehicleDB) and refreshed in loop mode the same way brigades.json is -
+// by polling a util.FetchSource no more often than a given period (see Update). A vehicle
+// number missing from the loaded file falls back to FleetRangeCapabilities.
+type VehicleDB struct {
+	fetcher *util.Fetcher[map[string]Capabilities]
+	entries map[string]Capabilities
+}
+
+// NewVehicleDB builds a VehicleDB polling source no more often than checkPeriod.
+func NewVehicleDB(source util.FetchSource, checkPeriod time.Duration) *VehicleDB {
+	db := &VehicleDB{}
+	db.fetcher = &util.Fetcher[map[string]Capabilities]{
+		Source: source,
+		Period: checkPeriod,
+		Decode: decodeVehicleDB,
+		OnUpdate: func(entries map[string]Capabilities) error {
+			db.entries = entries
+			return nil
+		},
+	}
+	return db
+}
+
+// Update reloads the vehicle database if its source has changed (or on the first call).
+func (db *VehicleDB) Update(ctx context.Context) error {
+	_, err := db.fetcher.Poll(ctx, db.entries == nil)
+	return err
+}
+
+// Capabilities returns the Capabilities known for vehicleNumber, falling back to
+// FleetRangeCapabilities when db is nil or its loaded mapping doesn't cover vehicleNumber.
+func (db *VehicleDB) Capabilities(vehicleNumber string) Capabilities {
+	if db != nil {
+		if c, ok := db.entries[vehicleNumber]; ok {
+			return c
+		}
+	}
+	return FleetRangeCapabilities(vehicleNumber)
+}
+
+// decodeVehicleDB parses a vehicle-number -> Capabilities side-file, as either JSON (an
+// object of vehicle_number -> array of capability codes, e.g. {"1009": ["WC", "LF"]}) or
+// CSV (a vehicle_number column plus one "0"/"1" column per capability code, header
+// vehicle_number,WC,LF,AC,BA,USB). The format is sniffed from the first non-whitespace
+// byte: "{" is JSON, anything else is treated as CSV.
+func decodeVehicleDB(raw []byte) (map[string]Capabilities, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return decodeVehicleDBJSON(trimmed)
+	}
+	return decodeVehicleDBCSV(trimmed)
+}
+
+func decodeVehicleDBJSON(raw []byte) (map[string]Capabilities, error) {
+	var parsed map[string][]string
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing vehicle db json: %w", err)
+	}
+
+	entries := make(map[string]Capabilities, len(parsed))
+	for vehicleNumber, codes := range parsed {
+		var c Capabilities
+		for _, code := range codes {
+			for _, cc := range capabilityCodes {
+				if strings.EqualFold(cc.code, code) {
+					c |= cc.bit
+				}
+			}
+		}
+		entries[vehicleNumber] = c
+	}
+	return entries, nil
+}
+
+func decodeVehicleDBCSV(raw []byte) (map[string]Capabilities, error) {
+	r := csv.NewReader(bytes.NewReader(raw))
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing vehicle db csv: %w", err)
+	} else if len(rows) == 0 {
+		return map[string]Capabilities{}, nil
+	}
+
+	header := rows[0]
+	columns := make(map[int]Capabilities, len(header)-1)
+	for i, name := range header[1:] {
+		for _, cc := range capabilityCodes {
+			if strings.EqualFold(cc.code, name) {
+				columns[i+1] = cc.bit
+			}
+		}
+	}
+
+	entries := make(map[string]Capabilities, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) == 0 {
+			continue
+		}
+		var c Capabilities
+		for i, bit := range columns {
+			if i < len(row) && (row[i] == "1" || strings.EqualFold(row[i], "true")) {
+				c |= bit
+			}
+		}
+		entries[row[0]] = c
+	}
+	return entries, nil
+}
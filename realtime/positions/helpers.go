@@ -2,6 +2,8 @@ package positions
 
 import (
 	"math"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
 )
 
 // radians - converts from degrees to radians
@@ -51,6 +53,82 @@ func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
 	return degrees(bearing)
 }
 
+// earthRadiusMeters is the Earth radius used for the local flat-earth approximation
+// below - accurate enough over the few tens of meters a vehicle is snapped across.
+const earthRadiusMeters = 6371000.0
+
+// metersPerDegreeLat returns how many meters a degree of latitude spans, which is
+// (very nearly) constant everywhere on Earth.
+func metersPerDegreeLat() float64 {
+	return earthRadiusMeters * math.Pi / 180
+}
+
+// metersPerDegreeLon returns how many meters a degree of longitude spans at a given
+// latitude - it shrinks to zero at the poles, hence the cos(lat) factor.
+func metersPerDegreeLon(lat float64) float64 {
+	return earthRadiusMeters * math.Pi / 180 * math.Cos(radians(lat))
+}
+
+// shapeSnapGateMeters is the maximum distance a position may be snapped onto a shape.
+// Beyond this the vehicle is assumed to be off-route (detour, depot, GPS drift) and
+// snapping would do more harm than good.
+const shapeSnapGateMeters = 30.0
+
+// snapToShapeSegment finds the point on shape (walked as a polyline, not just its
+// vertices) closest to (lat, lon), and the bearing of the segment it falls on. ok is
+// false when the shape has no points or the closest point is farther than
+// shapeSnapGateMeters, in which case (lat, lon) should be used unmodified.
+func snapToShapeSegment(shape []gtfs.ShapePoint, lat, lon float64) (snapLat, snapLon, bearing float64, ok bool) {
+	if len(shape) == 0 {
+		return
+	}
+
+	// Project shape points into a local, roughly-flat meter grid centered on (lat, lon) -
+	// accurate enough given points are only ever compared within shapeSnapGateMeters.
+	mLat := metersPerDegreeLat()
+	mLon := metersPerDegreeLon(lat)
+	toMeters := func(pLat, pLon float64) (north, east float64) {
+		return (pLat - lat) * mLat, (pLon - lon) * mLon
+	}
+
+	prevNorth, prevEast := toMeters(shape[0].Lat, shape[0].Lon)
+	bestNorth, bestEast := prevNorth, prevEast
+	bestDist := math.Hypot(prevNorth, prevEast)
+	var bestBearing float64
+
+	for i, pt := range shape[1:] {
+		north, east := toMeters(pt.Lat, pt.Lon)
+		segNorth, segEast := north-prevNorth, east-prevEast
+
+		// Project (0, 0) - the vehicle, relative to itself - onto the segment, clamped
+		// to the segment's extent
+		var projNorth, projEast float64
+		if segLenSq := segNorth*segNorth + segEast*segEast; segLenSq == 0 {
+			projNorth, projEast = prevNorth, prevEast
+		} else {
+			t := (-prevNorth*segNorth - prevEast*segEast) / segLenSq
+			t = math.Max(0, math.Min(1, t))
+			projNorth = prevNorth + t*segNorth
+			projEast = prevEast + t*segEast
+		}
+
+		// The first segment always counts, so a vehicle closest to shape[0] still gets
+		// that segment's bearing
+		if dist := math.Hypot(projNorth, projEast); dist < bestDist || i == 0 {
+			bestDist, bestNorth, bestEast = dist, projNorth, projEast
+			bestBearing = degrees(math.Atan2(segEast, segNorth))
+		}
+
+		prevNorth, prevEast = north, east
+	}
+
+	if bestDist > shapeSnapGateMeters {
+		return
+	}
+
+	return lat + bestNorth/mLat, lon + bestEast/mLon, bestBearing, true
+}
+
 // indexMatchingTrip returns the index of first *brigadeEntry
 // with the same trip as provided searchTrip.
 // Returns -1 if no matches were found.
@@ -0,0 +1,155 @@
+package positions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"time"
+)
+
+// gpsVarianceMeters is the assumed measurement noise (sigma²) of a raw GPS fix, used as
+// the Kalman filter's R. ~10 m sigma is typical for consumer-grade GPS in a city.
+const gpsVarianceMeters = 10.0 * 10.0
+
+// processNoiseAccel is the assumed standard deviation (m/s²) of unmodelled acceleration
+// between fixes, scaling the constant-velocity model's process noise (Q).
+const processNoiseAccel = 0.5
+
+// minSpeedForBearing is the filtered speed (m/s) above which a new bearing is trusted.
+// Below it, a vehicle's heading is too noisy to derive from its (near-zero) velocity, so
+// the previous bearing is kept instead.
+const minSpeedForBearing = 0.5
+
+// kalman1D is a constant-velocity Kalman filter for a single local axis (north or east):
+// Pos in meters relative to KalmanState's origin, Vel in m/s. Position and velocity along
+// different axes never interact in a constant-velocity model, so the full 4-state filter
+// described by "[lat, lon, v_north, v_east]" decomposes cleanly into two independent
+// 2-state filters of this shape.
+type kalman1D struct {
+	Pos, Vel float64
+
+	// Covariance matrix [[PCov, PVCov], [PVCov, VCov]].
+	PCov, PVCov, VCov float64
+}
+
+// predict advances the filter dt seconds under a constant-velocity model. The process
+// noise grows the covariance by the classic continuous white-noise-acceleration terms:
+// Δt³/3 for position, Δt²/2 for the position-velocity cross term, and Δt for velocity.
+func (k *kalman1D) predict(dt float64) {
+	k.Pos += k.Vel * dt
+
+	q := processNoiseAccel * processNoiseAccel
+	pCov := k.PCov + 2*dt*k.PVCov + dt*dt*k.VCov + q*dt*dt*dt/3
+	pvCov := k.PVCov + dt*k.VCov + q*dt*dt/2
+	vCov := k.VCov + q*dt
+
+	k.PCov, k.PVCov, k.VCov = pCov, pvCov, vCov
+}
+
+// update folds in a position measurement with variance measVar.
+func (k *kalman1D) update(measurement, measVar float64) {
+	innovation := measurement - k.Pos
+	s := k.PCov + measVar
+	kPos := k.PCov / s
+	kVel := k.PVCov / s
+
+	k.Pos += kPos * innovation
+	k.Vel += kVel * innovation
+
+	pCov := k.PCov - kPos*k.PCov
+	pvCov := k.PVCov - kPos*k.PVCov
+	vCov := k.VCov - kVel*k.PVCov
+
+	k.PCov, k.PVCov, k.VCov = pCov, pvCov, vCov
+}
+
+// KalmanState is the per-vehicle Kalman filter state carried between successive calls
+// to VehicleContainer.Smooth, smoothing away single-fix GPS noise. Lat/Lon/Bearing are
+// the filter's latest published output; North/East hold the underlying filter state, in
+// meters relative to OriginLat/OriginLon. It's JSON-marshallable so a caller can persist
+// it to disk between separate single-pass runs of this tool.
+type KalmanState struct {
+	Lat, Lon, Bearing    float64
+	Time                 time.Time
+	OriginLat, OriginLon float64
+	North, East          kalman1D
+}
+
+// NewKalmanState starts a fresh filter at an observed fix, with no velocity knowledge yet.
+func NewKalmanState(lat, lon float64, t time.Time) *KalmanState {
+	return &KalmanState{
+		Lat: lat, Lon: lon, Time: t,
+		OriginLat: lat, OriginLon: lon,
+	}
+}
+
+// metersNorth/metersEast/degreesLat/degreesLon convert between absolute lat/lon and the
+// local meter grid centered on OriginLat/OriginLon, as used by North/East.
+func (ks *KalmanState) metersNorth(lat float64) float64 {
+	return (lat - ks.OriginLat) * metersPerDegreeLat()
+}
+
+func (ks *KalmanState) metersEast(lon float64) float64 {
+	return (lon - ks.OriginLon) * metersPerDegreeLon(ks.OriginLat)
+}
+
+func (ks *KalmanState) degreesLat(north float64) float64 {
+	return ks.OriginLat + north/metersPerDegreeLat()
+}
+
+func (ks *KalmanState) degreesLon(east float64) float64 {
+	return ks.OriginLon + east/metersPerDegreeLon(ks.OriginLat)
+}
+
+// Update predicts the filter forward to t, folds in a new (lat, lon) fix, and refreshes
+// Lat/Lon/Bearing. Bearing is recalculated from the filtered velocity only once the
+// filtered speed exceeds minSpeedForBearing; a slow or stationary vehicle keeps its
+// previous bearing instead of chasing GPS noise.
+func (ks *KalmanState) Update(lat, lon float64, t time.Time) {
+	dt := t.Sub(ks.Time).Seconds()
+	if dt < 0 {
+		dt = 0
+	}
+
+	ks.North.predict(dt)
+	ks.East.predict(dt)
+
+	ks.North.update(ks.metersNorth(lat), gpsVarianceMeters)
+	ks.East.update(ks.metersEast(lon), gpsVarianceMeters)
+
+	ks.Lat = ks.degreesLat(ks.North.Pos)
+	ks.Lon = ks.degreesLon(ks.East.Pos)
+	ks.Time = t
+
+	if speed := math.Hypot(ks.North.Vel, ks.East.Vel); speed > minSpeedForBearing {
+		ks.Bearing = degrees(math.Atan2(ks.East.Vel, ks.North.Vel))
+	}
+}
+
+// LoadKalmanStates reads a map[string]*KalmanState previously written by
+// SaveKalmanStates. A missing file is not an error, as it simply means there's no prior
+// smoothing state yet (e.g. the very first run).
+func LoadKalmanStates(path string) (map[string]*KalmanState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var states map[string]*KalmanState
+	err = json.Unmarshal(b, &states)
+	return states, err
+}
+
+// SaveKalmanStates writes states to path as JSON, for a later LoadKalmanStates call
+// (typically the next run of this tool) to pick back up.
+func SaveKalmanStates(path string, states map[string]*KalmanState) error {
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o666)
+}
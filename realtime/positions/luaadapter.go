@@ -0,0 +1,292 @@
+package positions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cjoudrey/gluahttp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LuaAdapter drives the positions pipeline from an external Lua script, so operators can
+// support another city/feed (e.g. a scripts/krakow.lua or scripts/poznan.lua) without a
+// Go rebuild or a fork of this repo.
+//
+// A script must define a global fetch() function, taking no arguments and returning a
+// table of vehicle tables: {id=, lat=, lon=, line=, brigade=, timestamp=, trip_id=} (the
+// trip_id field is optional). fetch() is free to use the preloaded "http" module
+// (github.com/cjoudrey/gluahttp) to reach the upstream feed itself.
+//
+// A script may additionally define:
+//   - parse(raw): post-processes a raw string returned by fetch() instead of a table,
+//     for feeds that need custom decoding (e.g. a non-JSON wire format).
+//   - match(vehicle, prev, brigades, synctime): overrides the default Warsaw-style
+//     brigade-schedule trip matching. vehicle/prev are the same fields as above plus an
+//     optional trip_id; brigades is a list of {trip_id=, shape_id=, last_stop_id=,
+//     last_stop_lat=, last_stop_lon=, last_stop_timepoint=}; synctime is seconds since
+//     midnight. It must return the matched trip_id, or nil/"" if none.
+type LuaAdapter struct {
+	ScriptPath string
+
+	state *lua.LState
+}
+
+// NewLuaAdapter loads and runs the script at path, registering its globals. The returned
+// LuaAdapter owns a Lua state that must be released with Close.
+func NewLuaAdapter(path string, client *http.Client) (*LuaAdapter, error) {
+	state := lua.NewState()
+	state.PreloadModule("http", gluahttp.NewHttpModule(client).Loader)
+
+	if err := state.DoFile(path); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("loading lua adapter %s: %w", path, err)
+	}
+
+	if _, ok := state.GetGlobal("fetch").(*lua.LFunction); !ok {
+		state.Close()
+		return nil, fmt.Errorf("lua adapter %s does not define a fetch() function", path)
+	}
+
+	return &LuaAdapter{ScriptPath: path, state: state}, nil
+}
+
+// Close releases the underlying Lua state. Call it once the adapter is no longer used.
+func (a *LuaAdapter) Close() { a.state.Close() }
+
+// FetchRaw calls the script's fetch() function and hands back its result re-encoded as
+// JSON, so Parse has a single representation to work with regardless of whether fetch()
+// returned a ready vehicle table or a raw string for parse() to handle. ctx is unused: a Lua
+// fetch() call isn't cancellable mid-flight (gluahttp doesn't take a context), so it's taken
+// only to satisfy the Adapter interface.
+func (a *LuaAdapter) FetchRaw(ctx context.Context) (io.ReadCloser, error) {
+	if err := a.state.CallByParam(
+		lua.P{Fn: a.state.GetGlobal("fetch"), NRet: 1, Protect: true},
+	); err != nil {
+		return nil, fmt.Errorf("lua fetch(): %w", err)
+	}
+	ret := a.state.Get(-1)
+	a.state.Pop(1)
+
+	raw, err := json.Marshal(luaToGo(ret))
+	if err != nil {
+		return nil, fmt.Errorf("lua fetch() returned a value that can't be encoded: %w", err)
+	}
+	return io.NopCloser(bytes.NewReader(raw)), nil
+}
+
+// Parse decodes the JSON produced by FetchRaw into Vehicles. When the script defines a
+// parse() function, the raw value is run through it first, which lets fetch() return a
+// plain string for feeds that aren't naturally table-shaped.
+func (a *LuaAdapter) Parse(raw io.Reader) ([]*Vehicle, error) {
+	body, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if parseFn, ok := a.state.GetGlobal("parse").(*lua.LFunction); ok {
+		// Hand parse() the string fetch() returned, not its JSON-quoted encoding
+		arg := string(body)
+		var s string
+		if json.Unmarshal(body, &s) == nil {
+			arg = s
+		}
+		if err := a.state.CallByParam(
+			lua.P{Fn: parseFn, NRet: 1, Protect: true}, lua.LString(arg),
+		); err != nil {
+			return nil, fmt.Errorf("lua parse(): %w", err)
+		}
+		ret := a.state.Get(-1)
+		a.state.Pop(1)
+
+		body, err = json.Marshal(luaToGo(ret))
+		if err != nil {
+			return nil, fmt.Errorf("lua parse() returned a value that can't be encoded: %w", err)
+		}
+	}
+
+	var rows []luaVehicleRow
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("decoding vehicles from %s: %w", a.ScriptPath, err)
+	}
+
+	vehicles := make([]*Vehicle, 0, len(rows))
+	for _, row := range rows {
+		v := &Vehicle{
+			ID:         row.ID,
+			Lat:        row.Lat,
+			Lon:        row.Lon,
+			SideNumber: row.ID,
+			Line:       row.Line,
+			Brigade:    row.Brigade,
+			Trip:       row.TripID,
+		}
+
+		v.TimeObj, err = time.Parse("2006-01-02 15:04:05", row.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle %s: invalid timestamp %q: %w", v.ID, row.Timestamp, err)
+		}
+		v.Time = v.TimeObj.Format("2006-01-02T15:04:05")
+
+		vehicles = append(vehicles, v)
+	}
+	return vehicles, nil
+}
+
+// MatchTrip calls the script's match() function when defined, falling back to the same
+// brigade-schedule heuristic the Warsaw adapter uses.
+func (a *LuaAdapter) MatchTrip(v, pv *Vehicle, brigades []*brigadeEntry, synctime compareTime) error {
+	matchFn, ok := a.state.GetGlobal("match").(*lua.LFunction)
+	if !ok {
+		return v.MatchTrip(pv, synctime, brigades)
+	}
+
+	if err := a.state.CallByParam(
+		lua.P{Fn: matchFn, NRet: 1, Protect: true},
+		goToLua(a.state, luaVehicleRowOf(v)),
+		goToLua(a.state, luaVehicleRowOfOrNil(pv)),
+		goToLua(a.state, luaBrigadeRowsOf(brigades)),
+		lua.LNumber(synctime.Seconds()),
+	); err != nil {
+		return fmt.Errorf("lua match(): %w", err)
+	}
+
+	ret := a.state.Get(-1)
+	a.state.Pop(1)
+	if tripID, ok := ret.(lua.LString); ok {
+		v.Trip = string(tripID)
+	}
+	return nil
+}
+
+// luaVehicleRow is the wire shape exchanged with a Lua script for a single vehicle.
+type luaVehicleRow struct {
+	ID        string  `json:"id"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Line      string  `json:"line"`
+	Brigade   string  `json:"brigade"`
+	Timestamp string  `json:"timestamp"`
+	TripID    string  `json:"trip_id"`
+}
+
+func luaVehicleRowOf(v *Vehicle) luaVehicleRow {
+	return luaVehicleRow{
+		ID: v.ID, Lat: v.Lat, Lon: v.Lon, Line: v.Line, Brigade: v.Brigade,
+		Timestamp: v.Time, TripID: v.Trip,
+	}
+}
+
+// luaVehicleRowOfOrNil returns nil for a nil Vehicle, rather than a zero-valued row, so
+// the script can tell "no previous vehicle" apart from an empty one.
+func luaVehicleRowOfOrNil(v *Vehicle) interface{} {
+	if v == nil {
+		return nil
+	}
+	return luaVehicleRowOf(v)
+}
+
+// luaBrigadeRow is the wire shape exchanged with a Lua script for a single brigades.json
+// schedule entry.
+type luaBrigadeRow struct {
+	TripID            string  `json:"trip_id"`
+	ShapeID           string  `json:"shape_id"`
+	LastStopID        string  `json:"last_stop_id"`
+	LastStopLat       float64 `json:"last_stop_lat"`
+	LastStopLon       float64 `json:"last_stop_lon"`
+	LastStopTimepoint string  `json:"last_stop_timepoint"`
+}
+
+func luaBrigadeRowsOf(brigades []*brigadeEntry) []luaBrigadeRow {
+	rows := make([]luaBrigadeRow, len(brigades))
+	for i, b := range brigades {
+		rows[i] = luaBrigadeRow{
+			TripID: b.TripID, ShapeID: b.ShapeID, LastStopID: b.LastStopID,
+			LastStopLat: b.LastStopPos[0], LastStopLon: b.LastStopPos[1],
+			LastStopTimepoint: b.LastStopTimepoint,
+		}
+	}
+	return rows
+}
+
+// luaToGo converts a lua.LValue tree into plain Go values (map[string]interface{},
+// []interface{}, string, float64, bool, nil), so it can be passed to json.Marshal.
+func luaToGo(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case *lua.LTable:
+		// A table with only consecutive integer keys starting at 1 is treated as an
+		// array, matching how gopher-lua represents a Lua array-style table.
+		if n := v.Len(); n > 0 {
+			arr := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				arr[i-1] = luaToGo(v.RawGetInt(i))
+			}
+			return arr
+		}
+
+		obj := make(map[string]interface{})
+		v.ForEach(func(k, val lua.LValue) {
+			obj[k.String()] = luaToGo(val)
+		})
+		return obj
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	default:
+		return nil
+	}
+}
+
+// goToLua converts a Go value built from maps/slices/primitives (as produced by
+// encoding/json-compatible structs) into a lua.LValue tree, using state to allocate
+// tables so they're sized and managed the same way as tables the script itself creates.
+func goToLua(state *lua.LState, v interface{}) lua.LValue {
+	if v == nil {
+		return lua.LNil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return lua.LNil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return lua.LNil
+	}
+	return goJSONToLua(state, decoded)
+}
+
+func goJSONToLua(state *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		tbl := state.NewTable()
+		for i, elem := range v {
+			tbl.RawSetInt(i+1, goJSONToLua(state, elem))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := state.NewTable()
+		for k, val := range v {
+			tbl.RawSetString(k, goJSONToLua(state, val))
+		}
+		return tbl
+	default:
+		return lua.LNil
+	}
+}
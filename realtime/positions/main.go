@@ -3,8 +3,13 @@ package positions
 // cSpell: words cenkalti
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
@@ -14,39 +19,134 @@ import (
 
 // Options represent options for creating positions GTFS-Realtime
 type Options struct {
-	GtfsRtTarget  string
-	JSONTarget    string
-	HumanReadable bool
-	Apikey        string
+	GtfsRtTarget   string
+	JSONTarget     string
+	HumanReadable  bool
+	Apikey         string
+	ThrowAPIErrors bool
+
+	// Adapter overrides the feed/city this instance pulls positions from. When nil,
+	// Main and Loop default to WarsawAdapter, the api.um.warszawa.pl-backed adapter.
+	Adapter Adapter
+
+	// KalmanStateFile, when non-empty, turns on Kalman-filtered position smoothing and
+	// shape map-matching (see VehicleContainer.Smooth): the per-vehicle filter state is
+	// loaded from and saved back to this JSON file, so smoothing survives across
+	// separate single-pass runs of this tool, not just a single -loop process.
+	KalmanStateFile string
+
+	// DiffGtfsRtTarget, when non-empty, turns on differential GTFS-RT output (see
+	// VehicleContainer.SavePB): a second feed is written there, diffed against the full
+	// feed from the previous run. FullSnapshotEvery, if positive, is how many diffs run
+	// between full resyncs of that file.
+	DiffGtfsRtTarget  string
+	FullSnapshotEvery int
+
+	// PostUpdate, when non-nil, runs after each successful update inside Loop/LoopContext,
+	// receiving that round's freshly matched vehicles. This lets a caller rebuild a
+	// companion feed (see tripupdates.Loop) from the same match pass, without this package
+	// knowing anything about trip updates.
+	PostUpdate func(vehicles map[string]*Vehicle) error
+
+	// UpstreamSources lists already-published GTFS-RT VehiclePositions feeds to merge into
+	// the output, in addition to the vehicles from Adapter - e.g. another operator's own
+	// feed that this tool doesn't otherwise generate.
+	UpstreamSources []*UpstreamSource
+
+	// Store, when non-nil, persists matched vehicles and per-GTFS-version brigade maps
+	// across restarts of Loop/LoopContext (see SQLiteStore). Left nil, Loop keeps this
+	// state in memory only, same as before Store existed.
+	Store Store
+
+	// OnContainer, when non-nil, is called with the matched VehicleContainer on every
+	// successful Create, before it's written to GtfsRtTarget/JSONTarget. This lets a caller
+	// (see realtime/server) publish the feed straight to HTTP clients instead of, or in
+	// addition to, the files this package writes itself.
+	OnContainer func(*VehicleContainer) error
+
+	// VehicleDB, when non-nil, fills in each matched vehicle's Capabilities (wheelchair
+	// accessibility, low floor, air conditioning, bikes allowed, USB charging), keyed by its
+	// side number. Left nil, FleetRangeCapabilities' numeric-range guess is used instead.
+	VehicleDB *VehicleDB
+
+	// OnError, when non-nil, is called with the error from a failed Create attempt inside
+	// Loop/LoopContext - including attempts the backoff policy will retry, not just the
+	// final failure that ends the loop - so a caller (see realtime/server) can track
+	// fetch/error counts independently of whether the loop itself keeps running.
+	OnError func(error)
 }
 
 // Create auto-magically creates realtime feeds with position data.
 // Create is designed to run a loop, hence it doesn't contain logic to load
 // brigades JSON
-func Create(api VehicleAPI, brigadeMap map[string][]*brigadeEntry, prevVehicles map[string]*Vehicle, opts Options) (map[string]*Vehicle, error) {
+func Create(ctx context.Context, adapter Adapter, data gtfsData, prevVehicles map[string]*Vehicle, opts Options) (map[string]*Vehicle, error) {
 	// 0. Prepare a container
 	container := &VehicleContainer{}
 	container.SyncTime = time.Now().In(util.WarsawTimezone)
 	container.CompareSyncTime = newCompareTimeFromTime(container.SyncTime)
 
-	// 1. Get data from the api
-	apiEntries, err := api.GetAll()
+	// 1. Fetch and parse the raw feed through the adapter
+	raw, err := adapter.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+
+	vehicles, err := adapter.Parse(raw)
 	if err != nil {
 		return nil, err
 	}
 
+	// 1a. Fill in each vehicle's Capabilities, unless its Adapter/Provider already supplied
+	// some (see APIVehicleEntry.Capabilities)
+	for _, v := range vehicles {
+		if v.Capabilities == 0 {
+			v.Capabilities = opts.VehicleDB.Capabilities(v.SideNumber)
+		}
+	}
+
+	// 1b. Merge in vehicles from upstream GTFS-RT feeds already published by other operators
+	for _, src := range opts.UpstreamSources {
+		upstreamVehicles, err := src.Vehicles(data.Trips, data.Routes)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, upstreamVehicles...)
+	}
+
 	// 2. Create Vehicle objects
-	err = container.Prepare(apiEntries)
+	err = container.Prepare(vehicles)
 	if err != nil {
 		return nil, err
 	}
 
 	// 3. Match all Vehicles to trips
-	err = container.MatchAll(brigadeMap, prevVehicles)
+	err = container.MatchAll(adapter, data.BrigadeMap, prevVehicles)
 	if err != nil {
 		return nil, err
 	}
 
+	// 3b. Optionally smooth positions with a Kalman filter and map-match onto shapes
+	if opts.KalmanStateFile != "" {
+		var kalmanStates map[string]*KalmanState
+		kalmanStates, err = LoadKalmanStates(opts.KalmanStateFile)
+		if err != nil {
+			return nil, err
+		}
+
+		kalmanStates = container.Smooth(kalmanStates, data.BrigadeMap, data.Shapes)
+
+		if err = SaveKalmanStates(opts.KalmanStateFile, kalmanStates); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.OnContainer != nil {
+		if err = opts.OnContainer(container); err != nil {
+			return container.Vehicles, err
+		}
+	}
+
 	// 4. Export to JSON
 	if opts.JSONTarget != "" {
 		err = container.SaveJSON(opts.JSONTarget)
@@ -57,89 +157,192 @@ func Create(api VehicleAPI, brigadeMap map[string][]*brigadeEntry, prevVehicles
 
 	// 5. Export to GTFS-RT
 	if opts.GtfsRtTarget != "" {
-		err = container.SavePB(opts.GtfsRtTarget, opts.HumanReadable)
+		err = container.SavePB(opts.GtfsRtTarget, opts.HumanReadable, opts.DiffGtfsRtTarget, opts.FullSnapshotEvery)
 	}
 
 	return container.Vehicles, err
 }
 
-// Main auto-magically creates vehicle position data
-func Main(client *http.Client, gtfsFile *gtfs.Gtfs, opts Options) (err error) {
+// Main auto-magically creates vehicle position data, returning the matched vehicles
+// so a caller can reuse them (e.g. to derive a companion trip_updates feed) without
+// repeating the API call and brigade matching
+func Main(client *http.Client, gtfsFile *gtfs.Gtfs, opts Options) (vehicles map[string]*Vehicle, err error) {
 	// Load brigades from gtfs
 	brigadeMap, err := loadBrigades(gtfsFile)
 	if err != nil {
 		return
 	}
 
+	// Load the vehicle capabilities database, if one was configured - Loop instead
+	// refreshes it every tick via LoopContext's own call to VehicleDB.Update
+	if opts.VehicleDB != nil {
+		if err = opts.VehicleDB.Update(context.Background()); err != nil {
+			return
+		}
+	}
+
 	// Create other objects required by the Create function
 	var prevVehicles map[string]*Vehicle
-	api := VehicleAPI{Key: opts.Apikey, Client: client}
+	adapter := opts.adapterOrDefault(client)
+	data := gtfsData{
+		BrigadeMap: brigadeMap,
+		Shapes:     gtfsFile.Shapes,
+		Trips:      gtfsFile.Trips,
+		Routes:     gtfsFile.Routes,
+	}
 
 	// Call Create
-	_, err = Create(api, brigadeMap, prevVehicles, opts)
+	vehicles, err = Create(context.Background(), adapter, data, prevVehicles, opts)
 	return
 }
 
-// routesResource is a pair of resource pointing to a GTFS file and a routeMap
+// adapterOrDefault returns opts.Adapter, falling back to a WarsawAdapter built from the
+// other Options fields when none was provided
+func (opts Options) adapterOrDefault(client *http.Client) Adapter {
+	if opts.Adapter != nil {
+		return opts.Adapter
+	}
+	return &WarsawAdapter{
+		API: VehicleAPI{Key: opts.Apikey, Client: client, ThrowAPIErrors: opts.ThrowAPIErrors},
+	}
+}
+
+// gtfsData is the brigade map, shapes, trips and routes derived from a single LoadAll()
+// pass over the GTFS used for matching - read together since they come from the same
+// fetched content. Trips/Routes are what UpstreamSource needs to resolve a vehicle's
+// (namespaced) trip_id/route_id.
+type gtfsData struct {
+	BrigadeMap map[string][]*brigadeEntry
+	Shapes     map[string][]gtfs.ShapePoint
+	Trips      map[string]gtfs.TripData
+	Routes     map[string]sort.StringSlice
+
+	// Version is a content hash of the GTFS this gtfsData was derived from, used together
+	// with the service date to key a Store's cached brigade map (see newBrigadesResource).
+	Version string
+}
+
+// brigadesResource wraps a util.Fetcher that (re)loads the brigade map and shapes
+// whenever the underlying GTFS changes.
 type brigadesResource struct {
-	Resource   util.Resource
+	Fetcher    *util.Fetcher[gtfsData]
 	BrigadeMap map[string][]*brigadeEntry
+	Shapes     map[string][]gtfs.ShapePoint
+	Trips      map[string]gtfs.TripData
+	Routes     map[string]sort.StringSlice
+	Version    string
+
+	// Store, when non-nil, caches BrigadeMap by gtfs content hash, so a restart that sees
+	// the same GTFS content it saw before doesn't have to recompute it.
+	Store Store
+
+	// lastServiceDate is the service date (see util.ServiceDate) brigades were last loaded for.
+	lastServiceDate string
 }
 
-func (rr *brigadesResource) ShouldUpdate() (bool, error) {
-	// Force a refresh at 3:00, when a new service date begins operating
-	gotDate := util.ServiceDate(rr.Resource.LastCheck().In(util.WarsawTimezone))
-	expectedDate := util.ServiceDate(time.Now().In(util.WarsawTimezone))
-	if expectedDate != gotDate {
-		return true, nil
-	}
+// newBrigadesResource builds a brigadesResource polling source no more often than checkPeriod
+func newBrigadesResource(source util.FetchSource, checkPeriod time.Duration, store Store) *brigadesResource {
+	rr := &brigadesResource{Store: store}
+	rr.Fetcher = &util.Fetcher[gtfsData]{
+		Source: source,
+		Period: checkPeriod,
+		Decode: func(raw []byte) (gtfsData, error) {
+			version := fmt.Sprintf("%x", sha256.Sum256(raw))
 
-	// Check if the GTFS has changed
-	return rr.Resource.Check()
+			gtfsFile, err := gtfs.NewGtfsFromReaderAt(bytes.NewReader(raw), int64(len(raw)))
+			if err != nil {
+				return gtfsData{}, err
+			}
+			defer gtfsFile.Close()
+
+			if err := gtfsFile.LoadAll(); err != nil {
+				return gtfsData{}, err
+			}
+
+			// Which trips are active depends on the service date as well as the content
+			storeKey := version + "/" + util.ServiceDate(gtfsFile.SyncTime.In(util.WarsawTimezone))
+
+			var brigadeMap map[string][]*brigadeEntry
+			if rr.Store != nil {
+				brigadeMap, err = rr.Store.LoadBrigadeMap(storeKey)
+				if err != nil {
+					return gtfsData{}, err
+				}
+			}
+			if brigadeMap == nil {
+				brigadeMap, err = loadBrigades(gtfsFile)
+				if err != nil {
+					return gtfsData{}, err
+				}
+				if rr.Store != nil {
+					if err := rr.Store.SaveBrigadeMap(storeKey, brigadeMap); err != nil {
+						return gtfsData{}, err
+					}
+				}
+			}
+
+			return gtfsData{
+				BrigadeMap: brigadeMap,
+				Shapes:     gtfsFile.Shapes,
+				Trips:      gtfsFile.Trips,
+				Routes:     gtfsFile.Routes,
+				Version:    version,
+			}, nil
+		},
+		OnUpdate: func(data gtfsData) error {
+			rr.BrigadeMap = data.BrigadeMap
+			rr.Shapes = data.Shapes
+			rr.Trips = data.Trips
+			rr.Routes = data.Routes
+			rr.Version = data.Version
+			return nil
+		},
+	}
+	return rr
 }
 
-// Update automatically updates the RouteMap if the Resource has changed
-func (rr *brigadesResource) Update() error {
-	// Check for GTFS updates
-	shouldUpdate, err := rr.ShouldUpdate()
+// Update automatically reloads the brigade map and shapes if the GTFS has changed.
+// It also forces a full reload at 3:00, when a new service date begins operating: which
+// services run today is derived from calendar dates baked into the GTFS content itself, so
+// even an unchanged file can produce a different BrigadeMap once the service date rolls over.
+// The source's conditional (ETag/Last-Modified) state is cleared in that case, since otherwise
+// an unchanged file would be reported as a 304/not-modified and skip the reload entirely.
+func (rr *brigadesResource) Update(ctx context.Context) error {
+	expectedDate := util.ServiceDate(time.Now().In(util.WarsawTimezone))
+	force := rr.BrigadeMap == nil || expectedDate != rr.lastServiceDate
+	if force {
+		rr.Fetcher.Source.SetConditional(time.Time{}, "")
+	}
+
+	updated, err := rr.Fetcher.Poll(ctx, force)
 	if err != nil {
 		return err
-	} else if shouldUpdate || rr.BrigadeMap == nil {
+	} else if updated {
 		log.Println("gtfs changed, reloading")
+	}
+	rr.lastServiceDate = expectedDate
+	return nil
+}
 
-		// Fetch the new GTFS
-		gtfsContent, err := rr.Resource.Fetch()
-		if err != nil {
-			return err
-		}
-		defer gtfsContent.Close()
-
-		// Load the new GTFS
-		gtfsFile, err := gtfs.NewGtfsFromReader(gtfsContent)
-		if err != nil {
-			return err
-		}
-		defer gtfsFile.Close()
-		err = gtfsFile.LoadAll()
-		if err != nil {
-			return err
-		}
+// Loop automatically updates the GTFS-RT Positions files.
+// It's a thin wrapper around LoopContext using context.Background().
+func Loop(client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, opts Options) error {
+	return LoopContext(context.Background(), client, gtfsSource, checkPeriod, sleepTime, opts)
+}
 
-		// Re-load brigades
-		rr.BrigadeMap, err = loadBrigades(gtfsFile)
+// LoopContext is Loop with a caller-provided context. Cancelling ctx stops the loop
+// (returning ctx.Err()) instead of waiting out the current sleep or backoff.
+func LoopContext(ctx context.Context, client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, opts Options) (err error) {
+	var prevPositions map[string]*Vehicle
+	if opts.Store != nil {
+		prevPositions, err = opts.Store.LoadVehicles()
 		if err != nil {
 			return err
 		}
 	}
-	return nil
-}
 
-// Loop automatically updates the GTFS-RT Positions files
-func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Duration, opts Options) (err error) {
-	// Automatic wrapper around the resource
-	var prevPositions map[string]*Vehicle
-	api := VehicleAPI{Key: opts.Apikey, Client: client}
-	br := brigadesResource{Resource: gtfsResource}
+	adapter := opts.adapterOrDefault(client)
+	br := newBrigadesResource(gtfsSource, checkPeriod, opts.Store)
 
 	// Backoff shit
 	backoff := &backoff.ExponentialBackOff{
@@ -154,11 +357,18 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 
 	for {
 		// Try to update brigades.json
-		err = br.Update()
+		err = br.Update(ctx)
 		if err != nil {
 			return
 		}
 
+		// Try to refresh the vehicle capabilities database, if one was configured
+		if opts.VehicleDB != nil {
+			if err = opts.VehicleDB.Update(ctx); err != nil {
+				return
+			}
+		}
+
 		// Try updating the GTFS-RT
 		backoff.Reset()
 		for sleep := time.Duration(0); sleep != backoff.Stop; sleep = backoff.NextBackOff() {
@@ -171,17 +381,38 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 					sleepUntil, err.Error(),
 				)
 
-				// Sleep for the backoff
-				time.Sleep(sleep)
+				// Sleep for the backoff, unless ctx is cancelled first
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(sleep):
+				}
 			}
 
 			// Try to update the GTFS-RT
+			data := gtfsData{
+				BrigadeMap: br.BrigadeMap, Shapes: br.Shapes,
+				Trips: br.Trips, Routes: br.Routes, Version: br.Version,
+			}
 			var newPositions map[string]*Vehicle
-			newPositions, err = Create(api, br.BrigadeMap, prevPositions, opts)
+			newPositions, err = Create(ctx, adapter, data, prevPositions, opts)
+			if err != nil && opts.OnError != nil {
+				opts.OnError(err)
+			}
 
 			// If no errors were encountered, break out of the backoff loop
 			if err == nil {
 				prevPositions, newPositions = newPositions, nil
+				if opts.Store != nil {
+					if saveErr := opts.Store.SaveVehicles(prevPositions); saveErr != nil {
+						log.Printf("Saving vehicles to Store failed: %v\n", saveErr)
+					}
+				}
+				if opts.PostUpdate != nil {
+					if hookErr := opts.PostUpdate(prevPositions); hookErr != nil {
+						log.Printf("PostUpdate hook failed: %v\n", hookErr)
+					}
+				}
 				log.Println("GTFS-RT Positions updated successfully.")
 				break
 			}
@@ -190,8 +421,11 @@ func Loop(client *http.Client, gtfsResource util.Resource, sleepTime time.Durati
 			return
 		}
 
-		// Sleep until next try
-		time.Sleep(sleepTime)
+		// Sleep until next try, unless ctx is cancelled first
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleepTime):
+		}
 	}
-
 }
@@ -0,0 +1,42 @@
+package positions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Provider is the pluggable piece behind WarsawAdapter's fetch step: something that can
+// retrieve this tick's raw vehicle positions, already normalized into APIVehicleEntry
+// objects. WarsawProvider wraps the default api.um.warszawa.pl endpoint; GtfsRtProvider and
+// SiriVMProvider let the same WarsawAdapter/downstream pipeline (Parse, MatchTrip,
+// VehicleContainer) serve a feed that isn't Warsaw's own REST API at all.
+type Provider interface {
+	Fetch(ctx context.Context) ([]*APIVehicleEntry, error)
+}
+
+// ProviderFactories maps a -provider flag value to a constructor for the matching Provider,
+// so warsawgtfs_realtime.go can dispatch by name instead of growing its own switch
+// statement every time a provider is added. apikey and providerURL are only meaningful to
+// the factories that need them ("warsaw" reads apikey, "gtfs-rt"/"siri-vm" read
+// providerURL) - the rest ignore whichever argument doesn't apply to them.
+var ProviderFactories = map[string]func(client *http.Client, apikey, providerURL string) (Provider, error){
+	"warsaw": func(client *http.Client, apikey, _ string) (Provider, error) {
+		if apikey == "" {
+			return nil, errors.New(`the "warsaw" provider requires an apikey`)
+		}
+		return &WarsawProvider{API: VehicleAPI{Key: apikey, Client: client}}, nil
+	},
+	"gtfs-rt": func(client *http.Client, _, providerURL string) (Provider, error) {
+		if providerURL == "" {
+			return nil, errors.New(`the "gtfs-rt" provider requires -provider-url`)
+		}
+		return NewGtfsRtProvider(client, providerURL), nil
+	},
+	"siri-vm": func(client *http.Client, _, providerURL string) (Provider, error) {
+		if providerURL == "" {
+			return nil, errors.New(`the "siri-vm" provider requires -provider-url`)
+		}
+		return NewSiriVMProvider(client, providerURL), nil
+	},
+}
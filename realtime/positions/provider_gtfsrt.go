@@ -0,0 +1,86 @@
+package positions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+// GtfsRtProvider polls an upstream GTFS-Realtime VehiclePositions feed (a serialized
+// transit_realtime.FeedMessage) and normalizes each VehiclePosition into an APIVehicleEntry,
+// so a feed already published in GTFS-RT can be re-served through the same
+// WarsawAdapter/VehicleContainer pipeline as the native api.um.warszawa.pl one.
+//
+// Unlike UpstreamSource (which merges an upstream feed's own trip_id straight into a
+// Vehicle), a VehiclePosition's trip_id/route_id don't survive the trip through
+// APIVehicleEntry - MatchTrip still re-derives the trip via the loaded brigades.json, using
+// route_id as Lines. Entries whose route_id doesn't correspond to a Warsaw line won't match
+// any trip and are simply dropped downstream, same as an unmatched vehicle from the native
+// feed. Prefer UpstreamSource when the upstream feed's own trip_id should be trusted as-is.
+type GtfsRtProvider struct {
+	source util.FetchSource
+	last   []*APIVehicleEntry
+}
+
+// NewGtfsRtProvider builds a GtfsRtProvider polling the given VehiclePositions .pb URL.
+func NewGtfsRtProvider(client *http.Client, url string) *GtfsRtProvider {
+	return &GtfsRtProvider{source: &util.HTTPFetchSource{Client: client, URL: url}}
+}
+
+// Fetch implements Provider. When the upstream payload hasn't changed since the last call
+// (a 304 response), the previous result is returned without re-parsing.
+func (p *GtfsRtProvider) Fetch(ctx context.Context) ([]*APIVehicleEntry, error) {
+	content, modified, etag, notModified, err := p.source.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("polling gtfs-rt provider: %w", err)
+	}
+	if notModified {
+		return p.last, nil
+	}
+	p.source.SetConditional(modified, etag)
+
+	var msg gtfsrt.FeedMessage
+	if err := proto.Unmarshal(content, &msg); err != nil {
+		return nil, fmt.Errorf("decoding gtfs-rt provider feed: %w", err)
+	}
+
+	entries := make([]*APIVehicleEntry, 0, len(msg.Entity))
+	for _, entity := range msg.Entity {
+		if e := entryFromVehiclePosition(entity); e != nil {
+			entries = append(entries, e)
+		}
+	}
+
+	p.last = entries
+	return entries, nil
+}
+
+// entryFromVehiclePosition converts a single VehiclePosition entity into an APIVehicleEntry,
+// or returns nil if the entity doesn't carry a position.
+func entryFromVehiclePosition(entity *gtfsrt.FeedEntity) *APIVehicleEntry {
+	vp := entity.GetVehicle()
+	pos := vp.GetPosition()
+	if vp == nil || pos == nil {
+		return nil
+	}
+
+	vehicleID := vp.GetVehicle().GetLabel()
+	if vehicleID == "" {
+		vehicleID = vp.GetVehicle().GetId()
+	}
+
+	timeObj := time.Unix(int64(vp.GetTimestamp()), 0).In(util.WarsawTimezone)
+
+	return &APIVehicleEntry{
+		Lat:           float64(pos.GetLatitude()),
+		Lon:           float64(pos.GetLongitude()),
+		Time:          timeObj.Format("2006-01-02 15:04:05"),
+		Lines:         vp.GetTrip().GetRouteId(),
+		VehicleNumber: vehicleID,
+	}
+}
@@ -0,0 +1,105 @@
+package positions
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+)
+
+// SiriVMProvider polls a SIRI-VM (Vehicle Monitoring) XML feed and normalizes each
+// VehicleActivity into an APIVehicleEntry, the same way GtfsRtProvider does for a GTFS-RT
+// VehiclePositions feed.
+//
+// This is a minimal skeleton covering the handful of VehicleActivity fields APIVehicleEntry
+// needs (location, recorded time, published line name, vehicle ref) - SIRI-VM has
+// considerably more structure (journey refs, calls, facility conditions) that real-world
+// producers vary on, and isn't modelled here. Extend siriVehicleActivity/entryFromSiriVM as
+// concrete feeds require it.
+type SiriVMProvider struct {
+	source util.FetchSource
+	last   []*APIVehicleEntry
+}
+
+// NewSiriVMProvider builds a SiriVMProvider polling the given SIRI-VM XML URL.
+func NewSiriVMProvider(client *http.Client, url string) *SiriVMProvider {
+	return &SiriVMProvider{source: &util.HTTPFetchSource{Client: client, URL: url}}
+}
+
+// Fetch implements Provider. When the upstream payload hasn't changed since the last call
+// (a 304 response), the previous result is returned without re-parsing.
+func (p *SiriVMProvider) Fetch(ctx context.Context) ([]*APIVehicleEntry, error) {
+	content, modified, etag, notModified, err := p.source.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("polling siri-vm provider: %w", err)
+	}
+	if notModified {
+		return p.last, nil
+	}
+	p.source.SetConditional(modified, etag)
+
+	var envelope siriVMDelivery
+	if err := xml.Unmarshal(content, &envelope); err != nil {
+		return nil, fmt.Errorf("decoding siri-vm provider feed: %w", err)
+	}
+
+	activities := envelope.ServiceDelivery.VehicleMonitoringDelivery.VehicleActivity
+	entries := make([]*APIVehicleEntry, 0, len(activities))
+	for _, a := range activities {
+		if e := entryFromSiriVM(a); e != nil {
+			entries = append(entries, e)
+		}
+	}
+
+	p.last = entries
+	return entries, nil
+}
+
+// siriVMDelivery is the subset of a SIRI <Siri><ServiceDelivery><VehicleMonitoringDelivery>
+// envelope this provider reads.
+type siriVMDelivery struct {
+	XMLName         xml.Name `xml:"Siri"`
+	ServiceDelivery struct {
+		VehicleMonitoringDelivery struct {
+			VehicleActivity []siriVehicleActivity `xml:"VehicleActivity"`
+		} `xml:"VehicleMonitoringDelivery"`
+	} `xml:"ServiceDelivery"`
+}
+
+// siriVehicleActivity is the subset of a <VehicleActivity> element this provider reads.
+type siriVehicleActivity struct {
+	RecordedAtTime          string `xml:"RecordedAtTime"`
+	MonitoredVehicleJourney struct {
+		PublishedLineName string `xml:"PublishedLineName"`
+		VehicleRef        string `xml:"VehicleRef"`
+		VehicleLocation   struct {
+			Latitude  float64 `xml:"Latitude"`
+			Longitude float64 `xml:"Longitude"`
+		} `xml:"VehicleLocation"`
+	} `xml:"MonitoredVehicleJourney"`
+}
+
+// entryFromSiriVM converts a single VehicleActivity element into an APIVehicleEntry, or
+// returns nil if it doesn't carry a usable location.
+func entryFromSiriVM(a siriVehicleActivity) *APIVehicleEntry {
+	loc := a.MonitoredVehicleJourney.VehicleLocation
+	if loc.Latitude == 0 && loc.Longitude == 0 {
+		return nil
+	}
+
+	timeObj, err := time.Parse(time.RFC3339, a.RecordedAtTime)
+	if err != nil {
+		timeObj = time.Now()
+	}
+
+	return &APIVehicleEntry{
+		Lat:           loc.Latitude,
+		Lon:           loc.Longitude,
+		Time:          timeObj.In(util.WarsawTimezone).Format("2006-01-02 15:04:05"),
+		Lines:         a.MonitoredVehicleJourney.PublishedLineName,
+		VehicleNumber: a.MonitoredVehicleJourney.VehicleRef,
+	}
+}
@@ -0,0 +1,17 @@
+package positions
+
+import "context"
+
+// WarsawProvider is the default Provider, backed by the api.um.warszawa.pl busestrams_get
+// endpoint (see VehicleAPI).
+type WarsawProvider struct {
+	API VehicleAPI
+}
+
+// Fetch implements Provider by calling the api.um.warszawa.pl endpoint for both trams and
+// buses. ctx is unused: VehicleAPI predates context threading in this package and issuing
+// its request through api.Client.Get directly is how it's always worked, so Fetch keeps that
+// rather than widening VehicleAPI's own signature for a single caller.
+func (p *WarsawProvider) Fetch(ctx context.Context) ([]*APIVehicleEntry, error) {
+	return p.API.GetAll()
+}
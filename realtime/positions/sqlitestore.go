@@ -0,0 +1,108 @@
+package positions
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is the default Store, keeping vehicles and brigade maps in a single SQLite
+// database file via the cgo-free modernc.org/sqlite driver, so a restarted Loop doesn't
+// have to re-warm either cache from scratch.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (creating if necessary) a SQLiteStore backed by the database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value BLOB NOT NULL)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// load unmarshals the value saved under key into v, leaving v untouched if key was never
+// saved.
+func (s *SQLiteStore) load(key string, v any) error {
+	var raw []byte
+	err := s.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, key).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// save marshals v and persists it under key, overwriting any previous value.
+func (s *SQLiteStore) save(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		key, raw,
+	)
+	return err
+}
+
+const vehiclesKey = "vehicles"
+
+// LoadVehicles implements Store
+func (s *SQLiteStore) LoadVehicles() (map[string]*Vehicle, error) {
+	var records map[string]vehicleRecord
+	if err := s.load(vehiclesKey, &records); err != nil {
+		return nil, err
+	}
+
+	if records == nil {
+		return nil, nil
+	}
+	vehicles := make(map[string]*Vehicle, len(records))
+	for id, r := range records {
+		vehicles[id] = r.toVehicle()
+	}
+	return vehicles, nil
+}
+
+// SaveVehicles implements Store
+func (s *SQLiteStore) SaveVehicles(vehicles map[string]*Vehicle) error {
+	records := make(map[string]vehicleRecord, len(vehicles))
+	for id, v := range vehicles {
+		records[id] = vehicleToRecord(v)
+	}
+	return s.save(vehiclesKey, records)
+}
+
+// brigadeMapKey is the kv table key a brigade map is saved under for a given GTFS version.
+func brigadeMapKey(gtfsVersion string) string {
+	return "brigademap:" + gtfsVersion
+}
+
+// LoadBrigadeMap implements Store
+func (s *SQLiteStore) LoadBrigadeMap(gtfsVersion string) (map[string][]*brigadeEntry, error) {
+	var m map[string][]*brigadeEntry
+	if err := s.load(brigadeMapKey(gtfsVersion), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SaveBrigadeMap implements Store
+func (s *SQLiteStore) SaveBrigadeMap(gtfsVersion string, m map[string][]*brigadeEntry) error {
+	return s.save(brigadeMapKey(gtfsVersion), m)
+}
@@ -0,0 +1,55 @@
+package positions
+
+import "time"
+
+// Store is a pluggable persistence backend for the state Loop/LoopContext would otherwise
+// only keep in memory: the vehicles matched on the last successful Create, and the brigade
+// map derived for a given GTFS version and service date (see brigadesResource.Update). A nil Store (the
+// default) keeps this state in memory only, same as before Store existed, so a restart
+// rebuilds everything from scratch.
+type Store interface {
+	// LoadVehicles returns the vehicles saved by the last SaveVehicles call, or a nil map
+	// if nothing was ever saved.
+	LoadVehicles() (map[string]*Vehicle, error)
+
+	// SaveVehicles persists vehicles, replacing whatever was saved before.
+	SaveVehicles(vehicles map[string]*Vehicle) error
+
+	// LoadBrigadeMap returns the brigade map previously saved for gtfsVersion (see
+	// SaveBrigadeMap), or a nil map if nothing was saved under that exact version.
+	LoadBrigadeMap(gtfsVersion string) (map[string][]*brigadeEntry, error)
+
+	// SaveBrigadeMap persists m as the brigade map for gtfsVersion, replacing whatever
+	// was saved under that version before.
+	SaveBrigadeMap(gtfsVersion string, m map[string][]*brigadeEntry) error
+}
+
+// vehicleRecord is the wire-shape a Vehicle (de)serializes to/from when persisted through a
+// Store. Unlike Vehicle's own JSON tags (meant for the public positions.json output), it
+// keeps the Line/Brigade/TimeObj fields a restarted Loop needs to resume matching.
+type vehicleRecord struct {
+	ID         string    `json:"id"`
+	Time       string    `json:"time"`
+	Lat        float64   `json:"lat"`
+	Lon        float64   `json:"lon"`
+	SideNumber string    `json:"side_number"`
+	Trip       string    `json:"trip"`
+	Bearing    float64   `json:"bearing"`
+	Line       string    `json:"line"`
+	Brigade    string    `json:"brigade"`
+	TimeObj    time.Time `json:"time_obj"`
+}
+
+func vehicleToRecord(v *Vehicle) vehicleRecord {
+	return vehicleRecord{
+		ID: v.ID, Time: v.Time, Lat: v.Lat, Lon: v.Lon, SideNumber: v.SideNumber,
+		Trip: v.Trip, Bearing: v.Bearing, Line: v.Line, Brigade: v.Brigade, TimeObj: v.TimeObj,
+	}
+}
+
+func (r vehicleRecord) toVehicle() *Vehicle {
+	return &Vehicle{
+		ID: r.ID, Time: r.Time, Lat: r.Lat, Lon: r.Lon, SideNumber: r.SideNumber,
+		Trip: r.Trip, Bearing: r.Bearing, Line: r.Line, Brigade: r.Brigade, TimeObj: r.TimeObj,
+	}
+}
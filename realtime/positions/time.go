@@ -1,6 +1,7 @@
 package positions
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -34,6 +35,30 @@ func newCompareTimeFromTime(tObj time.Time) (t compareTime) {
 	return
 }
 
+// compareTimeJSON is the wire-shape compareTime (de)serializes to/from, since compareTime's
+// own fields are unexported and wouldn't otherwise survive a round trip (see SQLiteStore).
+type compareTimeJSON struct {
+	H            int  `json:"h"`
+	M            int  `json:"m"`
+	S            int  `json:"s"`
+	UncertainDay bool `json:"uncertain_day"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (t compareTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compareTimeJSON{H: t.h, M: t.m, S: t.s, UncertainDay: t.uncertainDay})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *compareTime) UnmarshalJSON(data []byte) error {
+	var j compareTimeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	t.h, t.m, t.s, t.uncertainDay = j.H, j.M, j.S, j.UncertainDay
+	return nil
+}
+
 // Seconds returns the seconds-since-midnight count of a compareTime object
 func (t compareTime) Seconds() int {
 	return t.s + t.m*60 + t.h*3600
@@ -0,0 +1,128 @@
+package positions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// UpstreamSource polls an already-published GTFS-RT VehiclePositions feed - e.g. one run by
+// another operator this tool doesn't otherwise know about - and turns its entities into
+// Vehicles, so Create can merge them with the vehicles its own Adapter produced (see
+// Options.UpstreamSources and VehicleContainer.Prepare). A VehiclePosition only survives if
+// its trip_id (after namespacing, see TripIDPrefix/RouteIDPrefix) resolves against the
+// loaded GTFS and, unless both its route_id and RouteIDPrefix are empty, its namespaced
+// route_id resolves too and is that trip's route; anything else is dropped rather than
+// passed through with a dangling reference. A conditional GET (see util.HTTPFetchSource) keeps an unchanged
+// upstream payload from being re-parsed on every tick.
+type UpstreamSource struct {
+	// URL is the upstream GTFS-RT VehiclePositions feed.
+	URL string
+
+	// TripIDPrefix/RouteIDPrefix are prepended to a VehiclePosition's Trip.TripId/RouteId
+	// before looking them up in the loaded GTFS, namespacing this source's own id space onto
+	// the merged static GTFS - e.g. "KM:" for a Koleje Mazowieckie feed whose bare trip_ids
+	// aren't unique across operators. Left empty, ids are looked up unprefixed.
+	TripIDPrefix  string
+	RouteIDPrefix string
+
+	source util.FetchSource
+	last   []*Vehicle
+}
+
+// NewUpstreamSource builds an UpstreamSource polling url, namespacing its trip/route ids
+// with the given prefixes (either may be left empty).
+func NewUpstreamSource(client *http.Client, url, tripIDPrefix, routeIDPrefix string) *UpstreamSource {
+	return &UpstreamSource{
+		URL:           url,
+		TripIDPrefix:  tripIDPrefix,
+		RouteIDPrefix: routeIDPrefix,
+		source:        &util.HTTPFetchSource{Client: client, URL: url},
+	}
+}
+
+// Vehicles fetches and decodes this source's feed into Vehicles matched against trips and
+// routes (the loaded GTFS's Trips and Routes). When the upstream payload hasn't changed
+// since the last call (a 304 response), the previous result is returned without re-parsing.
+func (s *UpstreamSource) Vehicles(trips map[string]gtfs.TripData, routes map[string]sort.StringSlice) ([]*Vehicle, error) {
+	content, modified, etag, notModified, err := s.source.Read(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("polling upstream feed %s: %w", s.URL, err)
+	}
+	if notModified {
+		return s.last, nil
+	}
+	s.source.SetConditional(modified, etag)
+
+	var msg gtfsrt.FeedMessage
+	if err := proto.Unmarshal(content, &msg); err != nil {
+		return nil, fmt.Errorf("decoding upstream feed %s: %w", s.URL, err)
+	}
+
+	vehicles := make([]*Vehicle, 0, len(msg.Entity))
+	for _, entity := range msg.Entity {
+		if v := s.vehicleFromEntity(entity, trips, routes); v != nil {
+			vehicles = append(vehicles, v)
+		}
+	}
+
+	s.last = vehicles
+	return vehicles, nil
+}
+
+// vehicleFromEntity converts a single VehiclePosition entity into a Vehicle, or returns nil
+// if it doesn't carry a position, or its (namespaced) trip_id/route_id don't resolve.
+func (s *UpstreamSource) vehicleFromEntity(entity *gtfsrt.FeedEntity, trips map[string]gtfs.TripData, routes map[string]sort.StringSlice) *Vehicle {
+	vp := entity.GetVehicle()
+	pos := vp.GetPosition()
+	if vp == nil || pos == nil {
+		return nil
+	}
+
+	tripID := s.TripIDPrefix + vp.GetTrip().GetTripId()
+	trip, ok := trips[tripID]
+	if !ok {
+		return nil
+	}
+
+	routeID := s.RouteIDPrefix + vp.GetTrip().GetRouteId()
+	if routeID != "" {
+		known := false
+		for _, routeIDs := range routes {
+			known = known || util.StringSliceHas(routeIDs, routeID)
+		}
+		if !known || trip.Route != routeID {
+			return nil
+		}
+	}
+
+	id := entity.GetId()
+	if id == "" {
+		id = tripID
+	}
+
+	sideNumber := vp.GetVehicle().GetLabel()
+	if sideNumber == "" {
+		sideNumber = vp.GetVehicle().GetId()
+	}
+
+	timeObj := time.Unix(int64(vp.GetTimestamp()), 0)
+
+	return &Vehicle{
+		ID:         "U/" + s.TripIDPrefix + id,
+		Lat:        float64(pos.GetLatitude()),
+		Lon:        float64(pos.GetLongitude()),
+		SideNumber: sideNumber,
+		Trip:       tripID,
+		Bearing:    float64(pos.GetBearing()),
+		TimeObj:    timeObj,
+		Time:       timeObj.Format("2006-01-02T15:04:05"),
+	}
+}
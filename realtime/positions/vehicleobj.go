@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
 	"github.com/MKuranowski/WarsawGTFS/realtime/util"
 	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
 	"github.com/golang/protobuf/proto"
@@ -24,6 +27,11 @@ type Vehicle struct {
 	Trip    string  `json:"trip_id"`
 	Bearing float64 `json:"bearing,omitempty"`
 
+	// Capabilities is this vehicle's comfort/accessibility features, usually filled in by
+	// Create from Options.VehicleDB rather than by NewVehicle itself (see APIVehicleEntry.
+	// Capabilities) - a zero value just means "unknown", not "none of the above".
+	Capabilities Capabilities `json:"capabilities,omitempty"`
+
 	// Private fields, not meant to be exported
 	Line    string    `json:"-"`
 	Brigade string    `json:"-"`
@@ -34,12 +42,13 @@ type Vehicle struct {
 func NewVehicle(av *APIVehicleEntry) (v *Vehicle, err error) {
 	// Fill basic fields
 	v = &Vehicle{
-		ID:         "V/" + av.Lines + "/" + av.Brigade,
-		Lat:        av.Lat,
-		Lon:        av.Lon,
-		SideNumber: av.VehicleNumber,
-		Line:       av.Lines,
-		Brigade:    av.Brigade,
+		ID:           "V/" + av.Lines + "/" + av.Brigade,
+		Lat:          av.Lat,
+		Lon:          av.Lon,
+		SideNumber:   av.VehicleNumber,
+		Line:         av.Lines,
+		Brigade:      av.Brigade,
+		Capabilities: av.Capabilities,
 	}
 
 	// Try to parse the time
@@ -59,7 +68,7 @@ func (v *Vehicle) AsProto() *gtfsrt.FeedEntity {
 		Id: &v.ID,
 		Vehicle: &gtfsrt.VehiclePosition{
 			Trip:    &gtfsrt.TripDescriptor{TripId: &v.Trip},
-			Vehicle: &gtfsrt.VehicleDescriptor{Id: &v.ID, Label: &v.SideNumber},
+			Vehicle: &gtfsrt.VehicleDescriptor{Id: &v.ID, Label: proto.String(v.CapabilitiesLabel())},
 			Position: &gtfsrt.Position{
 				Latitude:  &lat32,
 				Longitude: &lon32,
@@ -218,66 +227,137 @@ func (vc *VehicleContainer) AsProto() *gtfsrt.FeedMessage {
 	return msg
 }
 
-// SavePB marshalls the container into a GTFS-Realtime protocol buffer file
-func (vc *VehicleContainer) SavePB(target string, humanReadable bool) (err error) {
-	// Open target file
+// SavePB marshalls the container into a GTFS-Realtime protocol buffer file at target, a
+// FULL_DATASET feed as always. When diffTarget is non-empty, a second, DIFFERENTIAL feed
+// is additionally written there: only the entities that changed since the full feed
+// previously written to target (read back before it's overwritten below), plus
+// is_deleted entities for vehicle IDs that disappeared since. Every fullEvery diffs (or
+// whenever there's no previous full feed to diff against), the diff feed is itself a
+// full resync instead, so a consumer reading only diffTarget can't drift forever from a
+// missed update; fullEvery<=0 disables the cadence, always diffing against target.
+func (vc *VehicleContainer) SavePB(target string, humanReadable bool, diffTarget string, fullEvery int) (err error) {
+	full := vc.AsProto()
+
+	if diffTarget != "" {
+		if err = vc.saveDiffPB(target, diffTarget, humanReadable, fullEvery, full); err != nil {
+			return
+		}
+	}
+
+	return savePBMessage(target, humanReadable, full)
+}
+
+// saveDiffPB writes diffTarget as described by SavePB's diffTarget/fullEvery parameters.
+// The cadence counter survives across separate runs of this tool in a small sibling file
+// next to diffTarget.
+func (vc *VehicleContainer) saveDiffPB(target, diffTarget string, humanReadable bool, fullEvery int, full *gtfsrt.FeedMessage) error {
+	countFile := diffTarget + ".count"
+	count := readDiffCount(countFile)
+
+	prev, err := readFeedMessage(target, humanReadable)
+	forceFull := err != nil || (fullEvery > 0 && count >= fullEvery)
+
+	var diff *gtfsrt.FeedMessage
+	if forceFull {
+		diff = full
+		count = 0
+	} else {
+		diff = util.FeedDiffer(prev, full)
+		count++
+	}
+
+	if err := writeDiffCount(countFile, count); err != nil {
+		return err
+	}
+
+	return savePBMessage(diffTarget, humanReadable, diff)
+}
+
+// savePBMessage marshals msg - either a full or differential FeedMessage - to a file at
+// target, in human-readable text form when humanReadable is set, binary otherwise.
+func savePBMessage(target string, humanReadable bool, msg *gtfsrt.FeedMessage) (err error) {
 	f, err := os.Create(target)
 	if err != nil {
 		return
 	}
 	defer f.Close()
 
-	// Marshall to GTFS-RT
 	if humanReadable {
-		// Human-readable format
-		err = proto.MarshalText(f, vc.AsProto())
-		if err != nil {
-			return
-		}
-	} else {
-		// Binary format
-		var b []byte
-		b, err = proto.Marshal(vc.AsProto())
-		if err != nil {
-			return
-		}
-		f.Write(b)
+		return proto.MarshalText(f, msg)
 	}
 
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
 	return
 }
 
-// Prepare initializes the vehiclecontainer.Vehicles map with
-// vehicle objects created from a sequence of apiVehicleEntry
-func (vc *VehicleContainer) Prepare(apiEntries []*APIVehicleEntry) error {
-	vc.Vehicles = make(map[string]*Vehicle, len(apiEntries))
+// readFeedMessage reads back a FeedMessage previously written by savePBMessage.
+func readFeedMessage(path string, humanReadable bool) (*gtfsrt.FeedMessage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, ae := range apiEntries {
-		v, err := NewVehicle(ae)
-		if err != nil {
-			return err
-		}
-		vc.Vehicles[v.ID] = v
+	msg := &gtfsrt.FeedMessage{}
+	if humanReadable {
+		err = proto.UnmarshalText(string(b), msg)
+	} else {
+		err = proto.Unmarshal(b, msg)
 	}
+	return msg, err
+}
 
+// readDiffCount reads the diff cadence counter from path. A missing or corrupt file is
+// treated as a fresh counter of 0 - worst case that just means one extra full resync.
+func readDiffCount(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// writeDiffCount persists the diff cadence counter to path.
+func writeDiffCount(path string, count int) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(count)), 0o666)
+}
+
+// Prepare initializes the vehiclecontainer.Vehicles map from a slice of already-parsed
+// Vehicle objects, as returned by an Adapter's Parse method
+func (vc *VehicleContainer) Prepare(vehicles []*Vehicle) error {
+	vc.Vehicles = make(map[string]*Vehicle, len(vehicles))
+	for _, v := range vehicles {
+		vc.Vehicles[v.ID] = v
+	}
 	return nil
 }
 
 // MatchAll tries to calculate the bearing and to match a vehicle to a prticular trip
-// for all its Vehicles. If a Vehicle still has an empty Trip field after calling its Match
-// function such vehicle is removed for the container.
-func (vc *VehicleContainer) MatchAll(brigadeMap map[string][]*brigadeEntry, prevVehicles map[string]*Vehicle) error {
+// for all its Vehicles, using adapter.MatchTrip. If a Vehicle still has an empty Trip
+// field after calling MatchTrip, such vehicle is removed for the container. Vehicles
+// that already carry a Trip (e.g. ones merged in from an UpstreamSource, already
+// resolved against the GTFS) skip MatchTrip entirely.
+func (vc *VehicleContainer) MatchAll(adapter Adapter, brigadeMap map[string][]*brigadeEntry, prevVehicles map[string]*Vehicle) error {
 	for vID, v := range vc.Vehicles {
-		// Try to find matching brigade fields
-		be := brigadeMap[vID]
-
 		// Try to find precious Vehicle for this ID
 		pv := prevVehicles[vID]
 
-		// Match this vehicle to a particular trip
-		err := v.MatchTrip(pv, vc.CompareSyncTime, be)
-		if err != nil {
-			return err
+		if v.Trip == "" {
+			// Try to find matching brigade fields
+			be := brigadeMap[vID]
+
+			// Match this vehicle to a particular trip
+			err := adapter.MatchTrip(v, pv, be, vc.CompareSyncTime)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Remove this vehicle if no trip was matched
@@ -292,3 +372,36 @@ func (vc *VehicleContainer) MatchAll(brigadeMap map[string][]*brigadeEntry, prev
 
 	return nil
 }
+
+// Smooth runs a Kalman-filtered smoothing pass over every matched Vehicle, using prev
+// as the per-vehicle filter state from the previous call (nil/missing entries start a
+// fresh filter) and returning the updated state for the caller to carry forward. It's
+// meant to run between MatchAll and AsProto/SaveJSON. When shapes is non-nil, the
+// filtered position is additionally map-matched onto the matched trip's shape: snapped
+// to the nearest point of the shape polyline within shapeSnapGateMeters, with the
+// bearing taken from that segment's tangent instead of the filter's own estimate.
+func (vc *VehicleContainer) Smooth(prev map[string]*KalmanState, brigadeMap map[string][]*brigadeEntry, shapes map[string][]gtfs.ShapePoint) map[string]*KalmanState {
+	next := make(map[string]*KalmanState, len(vc.Vehicles))
+
+	for vID, v := range vc.Vehicles {
+		ks := prev[vID]
+		if ks == nil {
+			ks = NewKalmanState(v.Lat, v.Lon, v.TimeObj)
+		}
+		ks.Update(v.Lat, v.Lon, v.TimeObj)
+		v.Lat, v.Lon, v.Bearing = ks.Lat, ks.Lon, ks.Bearing
+
+		if shapes != nil {
+			if entryIdx := indexMatchingTrip(brigadeMap[vID], v.Trip); entryIdx >= 0 {
+				shapeID := brigadeMap[vID][entryIdx].ShapeID
+				if snapLat, snapLon, tangent, ok := snapToShapeSegment(shapes[shapeID], v.Lat, v.Lon); ok {
+					v.Lat, v.Lon, v.Bearing = snapLat, snapLon, tangent
+				}
+			}
+		}
+
+		next[vID] = ks
+	}
+
+	return next
+}
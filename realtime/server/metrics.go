@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics tracks, per feed name, how many fetch/publish attempts a Loop has made, how many
+// of those failed, how many entities the feed currently holds and when it last succeeded -
+// exposed at /metrics in Prometheus text format (see Handler) so an operator can alert on a
+// stale or failing feed. The zero value is ready to use.
+type Metrics struct {
+	mu     sync.Mutex
+	byFeed map[string]*feedMetrics
+}
+
+// feedMetrics is a single feed's counters. Fields are atomics rather than being guarded by
+// Metrics.mu, so RecordSuccess/RecordError/the /metrics handler never block on each other
+// once the *feedMetrics itself has been looked up.
+type feedMetrics struct {
+	fetchTotal       atomic.Uint64
+	fetchErrorsTotal atomic.Uint64
+	entities         atomic.Int64
+	lastSuccessUnix  atomic.Int64
+}
+
+// feed returns the feedMetrics for name, creating it on first use.
+func (m *Metrics) feed(name string) *feedMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.byFeed == nil {
+		m.byFeed = make(map[string]*feedMetrics)
+	}
+	fm, ok := m.byFeed[name]
+	if !ok {
+		fm = &feedMetrics{}
+		m.byFeed[name] = fm
+	}
+	return fm
+}
+
+// RecordSuccess records a successful fetch/publish of the feed named name, holding
+// entityCount entities (e.g. len(AlertContainer.Alerts)).
+func (m *Metrics) RecordSuccess(name string, entityCount int) {
+	fm := m.feed(name)
+	fm.fetchTotal.Add(1)
+	fm.entities.Store(int64(entityCount))
+	fm.lastSuccessUnix.Store(time.Now().Unix())
+}
+
+// RecordError records a failed fetch attempt for the feed named name - e.g. from
+// alerts.Options.OnError/positions.Options.OnError.
+func (m *Metrics) RecordError(name string) {
+	fm := m.feed(name)
+	fm.fetchTotal.Add(1)
+	fm.fetchErrorsTotal.Add(1)
+}
+
+// names returns every feed name seen so far, sorted, so /metrics output is stable across
+// scrapes.
+func (m *Metrics) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.byFeed))
+	for name := range m.byFeed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeTo renders every tracked feed's counters in Prometheus text exposition format.
+func (m *Metrics) writeTo(buf *bytes.Buffer) {
+	names := m.names()
+	now := time.Now()
+
+	buf.WriteString("# HELP warsawgtfs_fetch_total Total number of feed fetch/publish attempts.\n")
+	buf.WriteString("# TYPE warsawgtfs_fetch_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "warsawgtfs_fetch_total{feed=%q} %d\n", name, m.feed(name).fetchTotal.Load())
+	}
+
+	buf.WriteString("# HELP warsawgtfs_fetch_errors_total Total number of failed feed fetch/publish attempts.\n")
+	buf.WriteString("# TYPE warsawgtfs_fetch_errors_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "warsawgtfs_fetch_errors_total{feed=%q} %d\n", name, m.feed(name).fetchErrorsTotal.Load())
+	}
+
+	buf.WriteString("# HELP warsawgtfs_entities Number of entities in the most recently published feed.\n")
+	buf.WriteString("# TYPE warsawgtfs_entities gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "warsawgtfs_entities{feed=%q} %d\n", name, m.feed(name).entities.Load())
+	}
+
+	buf.WriteString("# HELP warsawgtfs_last_success_timestamp_seconds Unix time of the feed's last successful publish.\n")
+	buf.WriteString("# TYPE warsawgtfs_last_success_timestamp_seconds gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "warsawgtfs_last_success_timestamp_seconds{feed=%q} %d\n", name, m.feed(name).lastSuccessUnix.Load())
+	}
+
+	buf.WriteString("# HELP warsawgtfs_source_age_seconds Seconds since the feed's last successful publish.\n")
+	buf.WriteString("# TYPE warsawgtfs_source_age_seconds gauge\n")
+	for _, name := range names {
+		last := m.feed(name).lastSuccessUnix.Load()
+		age := float64(0)
+		if last != 0 {
+			age = now.Sub(time.Unix(last, 0)).Seconds()
+		}
+		fmt.Fprintf(buf, "warsawgtfs_source_age_seconds{feed=%q} %g\n", name, age)
+	}
+}
+
+// serveMetrics returns an http.HandlerFunc serving m in Prometheus text exposition format.
+func (m *Metrics) serveMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		m.writeTo(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
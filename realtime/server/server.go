@@ -0,0 +1,242 @@
+// Package server exposes alerts/positions/trip_updates GTFS-Realtime feeds directly over
+// HTTP, instead of writing them to files for another process to publish.
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/alerts"
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/tripupdates"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// feed is a single feed's pre-serialized responses, built once per refresh (see
+// FeedStore.SetAlerts and friends) so the HTTP handlers never re-marshal on a per-request
+// basis. A nil byte slice means that encoding isn't offered for this feed. etag is a strong
+// ETag over modTime+pb (see etagFor), so a downstream aggregator can use If-None-Match
+// instead of, or alongside, If-Modified-Since.
+type feed struct {
+	pb, json, text []byte
+	modTime        time.Time
+	ttl            time.Duration
+	etag           string
+}
+
+// etagFor computes a strong ETag for a feed's binary content, derived from the
+// FeedMessage's header.timestamp (modTime) and a SHA-256 of the content itself, so two
+// refreshes producing byte-identical output still get distinguishable ETags if modTime
+// differs.
+func etagFor(modTime time.Time, pb []byte) string {
+	sum := sha256.Sum256(pb)
+	return fmt.Sprintf(`"%d-%x"`, modTime.Unix(), sum)
+}
+
+func (f *feed) remainingTTL() time.Duration {
+	remaining := f.ttl - time.Since(f.modTime)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+// FeedStore holds the most recently published alerts/vehicle_positions/trip_updates feeds,
+// each behind its own atomic.Pointer, so a Loop goroutine can publish a freshly built feed
+// while HTTP handlers keep serving the previous one lock-free.
+type FeedStore struct {
+	alerts      atomic.Pointer[feed]
+	positions   atomic.Pointer[feed]
+	tripUpdates atomic.Pointer[feed]
+
+	// Metrics tracks fetch/error counts and entity counts for every feed published through
+	// this FeedStore - see Handler, which serves it at /metrics. The zero value is ready to
+	// use, so this never needs to be set explicitly.
+	Metrics Metrics
+}
+
+// SetAlerts publishes c as the current alerts feed, to be served until ttl elapses or the
+// next SetAlerts call, whichever comes first.
+func (fs *FeedStore) SetAlerts(c *alerts.AlertContainer, ttl time.Duration) error {
+	pb, text, err := marshalProto(c.AsProto())
+	if err != nil {
+		return err
+	}
+
+	j, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fs.alerts.Store(&feed{pb: pb, text: text, json: j, modTime: c.Timestamp, ttl: ttl, etag: etagFor(c.Timestamp, pb)})
+	fs.Metrics.RecordSuccess("alerts", len(c.Alerts))
+	return nil
+}
+
+// SetPositions publishes c as the current vehicle_positions feed, to be served until ttl
+// elapses or the next SetPositions call, whichever comes first.
+func (fs *FeedStore) SetPositions(c *positions.VehicleContainer, ttl time.Duration) error {
+	pb, _, err := marshalProto(c.AsProto())
+	if err != nil {
+		return err
+	}
+	fs.positions.Store(&feed{pb: pb, modTime: c.SyncTime, ttl: ttl, etag: etagFor(c.SyncTime, pb)})
+	fs.Metrics.RecordSuccess("vehicle_positions", len(c.Vehicles))
+	return nil
+}
+
+// SetTripUpdates publishes c (built against gtfsFile) as the current trip_updates feed, to
+// be served until ttl elapses or the next SetTripUpdates call, whichever comes first.
+func (fs *FeedStore) SetTripUpdates(c *tripupdates.Container, gtfsFile *gtfs.Gtfs, ttl time.Duration) error {
+	msg := c.AsProto(gtfsFile)
+	pb, _, err := marshalProto(msg)
+	if err != nil {
+		return err
+	}
+	modTime := time.Unix(int64(msg.GetHeader().GetTimestamp()), 0)
+	fs.tripUpdates.Store(&feed{pb: pb, modTime: modTime, ttl: ttl, etag: etagFor(modTime, pb)})
+	fs.Metrics.RecordSuccess("trip_updates", len(msg.Entity))
+	return nil
+}
+
+// marshalProto returns msg marshalled to both its binary and human-readable text encodings.
+func marshalProto(msg *gtfsrt.FeedMessage) (pb, text []byte, err error) {
+	pb, err = proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = proto.MarshalText(&buf, msg); err != nil {
+		return
+	}
+	text = buf.Bytes()
+	return
+}
+
+// feedRoute pairs the URL path segment a feed is published at with where it's stored.
+type feedRoute struct {
+	name string
+	ptr  *atomic.Pointer[feed]
+}
+
+// Handler returns an http.Handler serving:
+//   - GET /gtfs-rt/alerts.pb, /gtfs-rt/alerts.json, /gtfs-rt/alerts.txt
+//   - GET /gtfs-rt/vehicle_positions.pb
+//   - GET /gtfs-rt/trip_updates.pb
+//   - GET /gtfs-rt.json, a GBFS-style discovery document listing every published feed's URL,
+//     last-updated timestamp and remaining TTL
+//
+// Every feed response sets Last-Modified, honors If-Modified-Since, and sets
+// Cache-Control: max-age=<remaining TTL>.
+func (fs *FeedStore) Handler() http.Handler {
+	routes := fs.routes()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gtfs-rt/alerts.pb", serveFeed(&fs.alerts, "application/x-protobuf", func(f *feed) []byte { return f.pb }))
+	mux.HandleFunc("GET /gtfs-rt/alerts.json", serveFeed(&fs.alerts, "application/json", func(f *feed) []byte { return f.json }))
+	mux.HandleFunc("GET /gtfs-rt/alerts.txt", serveFeed(&fs.alerts, "text/plain; charset=utf-8", func(f *feed) []byte { return f.text }))
+	mux.HandleFunc("GET /gtfs-rt/vehicle_positions.pb", serveFeed(&fs.positions, "application/x-protobuf", func(f *feed) []byte { return f.pb }))
+	mux.HandleFunc("GET /gtfs-rt/trip_updates.pb", serveFeed(&fs.tripUpdates, "application/x-protobuf", func(f *feed) []byte { return f.pb }))
+	mux.HandleFunc("GET /gtfs-rt.json", serveDiscovery(routes))
+	mux.HandleFunc("GET /metrics", fs.Metrics.serveMetrics())
+	return mux
+}
+
+// routes lists every feed this FeedStore can serve, in the order they should appear in the
+// discovery document.
+func (fs *FeedStore) routes() []feedRoute {
+	return []feedRoute{
+		{"alerts.pb", &fs.alerts},
+		{"vehicle_positions.pb", &fs.positions},
+		{"trip_updates.pb", &fs.tripUpdates},
+	}
+}
+
+// serveFeed returns an http.HandlerFunc serving whatever body(f) returns for the feed
+// currently stored in ptr.
+func serveFeed(ptr *atomic.Pointer[feed], contentType string, body func(*feed) []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := ptr.Load()
+		if f == nil {
+			http.Error(w, "feed not yet available", http.StatusServiceUnavailable)
+			return
+		}
+
+		data := body(f)
+		if data == nil {
+			http.Error(w, "this encoding isn't offered for this feed", http.StatusNotFound)
+			return
+		}
+
+		if inm := r.Header.Get("If-None-Match"); inm != "" && inm == f.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !f.modTime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", f.etag)
+		w.Header().Set("Last-Modified", f.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int64(f.remainingTTL().Seconds())))
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	}
+}
+
+// discoveryDoc is the GBFS-gbfs.json-style document served at /gtfs-rt.json.
+type discoveryDoc struct {
+	LastUpdated string          `json:"last_updated"`
+	Feeds       []discoveryFeed `json:"feeds"`
+}
+
+type discoveryFeed struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	LastUpdated string `json:"last_updated"`
+	TTL         int64  `json:"ttl"`
+}
+
+// serveDiscovery returns an http.HandlerFunc listing every currently-published feed in
+// routes, with its absolute URL, last-updated timestamp and remaining TTL.
+func serveDiscovery(routes []feedRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base := fmt.Sprintf("%s://%s/gtfs-rt/", scheme, r.Host)
+
+		doc := discoveryDoc{LastUpdated: time.Now().UTC().Format(time.RFC3339)}
+		for _, route := range routes {
+			f := route.ptr.Load()
+			if f == nil {
+				continue
+			}
+			doc.Feeds = append(doc.Feeds, discoveryFeed{
+				Name:        route.name,
+				URL:         base + route.name,
+				LastUpdated: f.modTime.UTC().Format(time.RFC3339),
+				TTL:         int64(f.remainingTTL().Seconds()),
+			})
+		}
+
+		body, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
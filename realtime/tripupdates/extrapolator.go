@@ -0,0 +1,92 @@
+package tripupdates
+
+import (
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// Extrapolator predicts arrival/departure delays for every not-yet-passed stop_time of a
+// matched vehicle's trip. trip is the matched trip (its StopTimes must be populated); gtfsFile
+// provides stop positions and, for shape-aware implementations, the trip's shape; v is the
+// vehicle's current matched position/timestamp; prev is the same vehicle as of the previous
+// update, or nil if there isn't one. Implementations should return updates only for stops at
+// or after the vehicle's current position - an update for an already-passed stop is
+// meaningless.
+type Extrapolator interface {
+	Extrapolate(trip gtfs.TripData, gtfsFile *gtfs.Gtfs, v, prev *positions.Vehicle) []*gtfsrt.TripUpdate_StopTimeUpdate
+}
+
+// LinearExtrapolator locates the vehicle's nearest stop_time by great-circle distance and
+// treats it as the reference stop: the difference between the vehicle's observed time and
+// that stop's scheduled time becomes the trip's current delay, applied unchanged to every
+// downstream stop. Predicted times are clamped to never fall before the vehicle's own
+// observation time, so a delay can never make a downstream arrival appear to be in the past.
+type LinearExtrapolator struct{}
+
+// Extrapolate implements Extrapolator
+func (LinearExtrapolator) Extrapolate(trip gtfs.TripData, gtfsFile *gtfs.Gtfs, v, prev *positions.Vehicle) []*gtfsrt.TripUpdate_StopTimeUpdate {
+	stopTimes, stops := trip.StopTimes, gtfsFile.Stops
+	if len(stopTimes) == 0 {
+		return nil
+	}
+
+	refIdx := nearestStopTime(stopTimes, stops, v.Lat, v.Lon)
+	refScheduled, err := util.ParseTimeToSeconds(stopTimes[refIdx].Timepoint)
+	if err != nil {
+		return nil
+	}
+
+	now := secondsSinceMidnight(v.TimeObj)
+	delay := now - int(refScheduled)
+
+	updates := make([]*gtfsrt.TripUpdate_StopTimeUpdate, 0, len(stopTimes)-refIdx)
+	predictedPrev := now
+
+	for i := refIdx; i < len(stopTimes); i++ {
+		st := stopTimes[i]
+		scheduled, err := util.ParseTimeToSeconds(st.Timepoint)
+		if err != nil {
+			continue
+		}
+
+		predicted := int(scheduled) + delay
+		// Clamp so this stop's predicted time never precedes the previous stop's -
+		// delay never propagates backwards in time.
+		if predicted < predictedPrev {
+			predicted = predictedPrev
+		}
+		predictedPrev = predicted
+
+		d := int32(predicted) - int32(scheduled)
+		stopID, stopSeq := st.StopID, uint32(st.Sequence)
+		updates = append(updates, &gtfsrt.TripUpdate_StopTimeUpdate{
+			StopSequence: &stopSeq,
+			StopId:       &stopID,
+			Arrival:      &gtfsrt.TripUpdate_StopTimeEvent{Delay: &d},
+			Departure:    &gtfsrt.TripUpdate_StopTimeEvent{Delay: &d},
+		})
+	}
+
+	return updates
+}
+
+// nearestStopTime returns the index of the stopTimes entry whose stop is closest to
+// (lat, lon) by great-circle distance. Stops missing from the stops map are skipped.
+func nearestStopTime(stopTimes []gtfs.StopTime, stops map[string][2]float64, lat, lon float64) int {
+	best := 0
+	bestDist := -1.0
+
+	for i, st := range stopTimes {
+		pos, ok := stops[st.StopID]
+		if !ok {
+			continue
+		}
+		if dist := haversine(lat, lon, pos[0], pos[1]); bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	return best
+}
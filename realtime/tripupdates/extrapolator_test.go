@@ -0,0 +1,62 @@
+package tripupdates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+)
+
+func TestNearestStopTime(t *testing.T) {
+	stopTimes := []gtfs.StopTime{
+		{StopID: "A", Timepoint: "10:00", Sequence: 0},
+		{StopID: "B", Timepoint: "10:05", Sequence: 1},
+		{StopID: "C", Timepoint: "10:10", Sequence: 2},
+	}
+	stops := map[string][2]float64{
+		"A": {52.00, 21.00},
+		"B": {52.10, 21.00},
+		"C": {52.20, 21.00},
+	}
+
+	if got := nearestStopTime(stopTimes, stops, 52.095, 21.00); got != 1 {
+		t.Errorf("nearestStopTime() = %d, want 1 (closest to B)", got)
+	}
+}
+
+func TestLinearExtrapolatorSkipsPassedStops(t *testing.T) {
+	trip := gtfs.TripData{
+		StopTimes: []gtfs.StopTime{
+			{StopID: "A", Timepoint: "10:00", Sequence: 0},
+			{StopID: "B", Timepoint: "10:05", Sequence: 1},
+			{StopID: "C", Timepoint: "10:10", Sequence: 2},
+		},
+	}
+	gtfsFile := &gtfs.Gtfs{
+		Stops: map[string][2]float64{
+			"A": {52.00, 21.00},
+			"B": {52.10, 21.00},
+			"C": {52.20, 21.00},
+		},
+	}
+	// The vehicle is right at B, running 2 minutes late.
+	v := &positions.Vehicle{
+		Lat:     52.10,
+		Lon:     21.00,
+		TimeObj: time.Date(2023, 5, 17, 10, 7, 0, 0, util.WarsawTimezone),
+	}
+
+	updates := LinearExtrapolator{}.Extrapolate(trip, gtfsFile, v, nil)
+
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2 (B and C only, A already passed)", len(updates))
+	}
+	if got := updates[0].GetStopId(); got != "B" {
+		t.Errorf("updates[0].StopId = %q, want B", got)
+	}
+	if got := updates[0].GetArrival().GetDelay(); got != 120 {
+		t.Errorf("updates[0] delay = %d, want 120 (2 minutes late)", got)
+	}
+}
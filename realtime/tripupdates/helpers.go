@@ -0,0 +1,40 @@
+package tripupdates
+
+import (
+	"math"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+)
+
+// terminusGateKm is how close (in km) a vehicle has to be to a trip's last stop
+// before its observed timestamp is trusted as the actual arrival time
+const terminusGateKm = 0.05
+
+// haversine calculates the distance between 2 points in km
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	lat1, lon1, lat2, lon2 = lat1*rad, lon1*rad, lat2*rad, lon2*rad
+
+	dlathalf := (lat2 - lat1) / 2
+	dlonhalf := (lon2 - lon1) / 2
+
+	a := math.Pow(math.Sin(dlathalf), 2)
+	b := math.Pow(math.Sin(dlonhalf), 2)
+	c := math.Sqrt(a + (b * math.Cos(lat1) * math.Cos(lat2)))
+
+	return 2 * 6371 * math.Asin(c)
+}
+
+// secondsSinceMidnight returns how many seconds after local midnight t falls,
+// matching the >24:00:00 convention GTFS timepoints use for night services:
+// a vehicle observed between midnight and 4 AM is assumed to still belong to
+// the previous, still-running service day.
+func secondsSinceMidnight(t time.Time) int {
+	t = t.In(util.WarsawTimezone)
+	h, m, s := t.Clock()
+	if h < 4 {
+		h += 24
+	}
+	return h*3600 + m*60 + s
+}
@@ -0,0 +1,200 @@
+package tripupdates
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// Options represents available options for generating trip updates
+type Options struct {
+	GtfsRtTarget  string
+	JSONTarget    string
+	HumanReadable bool
+
+	// Extrapolator predicts per-stop delays for every downstream stop of a matched trip,
+	// using its full, sequence-ordered StopTimes (see gtfs.TripData.StopTimes). Defaults to
+	// ShapeSpeedExtrapolator{} when nil, which needs shapes.txt to have been loaded - trips
+	// whose shape is missing fall back to LinearExtrapolator's nearest-stop delay. When the
+	// Extrapolator returns no updates, a single terminal-stop estimate is used instead. Trips
+	// whose StopTimes wasn't populated at all - e.g. excluded by the TripFilter LoadAll
+	// applies - have no LastStopTime either, and get no trip update.
+	Extrapolator Extrapolator
+
+	// OnContainer, when non-nil, is called with the built Container and the GTFS it was
+	// derived from on every successful Create, before it's written to
+	// GtfsRtTarget/JSONTarget. This lets a caller (see realtime/server) publish the feed
+	// straight to HTTP clients instead of, or in addition to, the files this package
+	// writes itself.
+	OnContainer func(*Container, *gtfs.Gtfs) error
+}
+
+// extrapolatorOrDefault returns opts.Extrapolator, falling back to a ShapeSpeedExtrapolator
+// when none was provided
+func (opts Options) extrapolatorOrDefault() Extrapolator {
+	if opts.Extrapolator != nil {
+		return opts.Extrapolator
+	}
+	return ShapeSpeedExtrapolator{}
+}
+
+// Create builds a GTFS-Realtime TripUpdates feed from vehicles that the positions package
+// has already matched to a trip_id. prevVehicles is the same vehicles as of the previous
+// update (used by Options.Extrapolator to derive a running speed), or nil if there isn't one.
+func Create(vehicles, prevVehicles map[string]*positions.Vehicle, gtfsFile *gtfs.Gtfs, opts Options) (err error) {
+	container := &Container{
+		SyncTime:     vehicles,
+		PrevVehicles: prevVehicles,
+		Extrapolator: opts.extrapolatorOrDefault(),
+	}
+
+	if opts.OnContainer != nil {
+		if err = opts.OnContainer(container, gtfsFile); err != nil {
+			return
+		}
+	}
+
+	// Export to JSON
+	if opts.JSONTarget != "" {
+		err = container.SaveJSON(opts.JSONTarget, gtfsFile)
+		if err != nil {
+			return
+		}
+	}
+
+	// Export to GTFS-Realtime
+	if opts.GtfsRtTarget != "" {
+		err = container.SavePB(opts.GtfsRtTarget, gtfsFile, opts.HumanReadable)
+	}
+	return
+}
+
+// Container is a thin wrapper reusing already-matched vehicles to build a
+// GTFS-Realtime TripUpdates FeedMessage
+type Container struct {
+	SyncTime     map[string]*positions.Vehicle
+	PrevVehicles map[string]*positions.Vehicle
+	Extrapolator Extrapolator
+}
+
+// AsProto marshals matched vehicles into a GTFS-Realtime FeedMessage of TripUpdates
+func (c *Container) AsProto(gtfsFile *gtfs.Gtfs) *gtfsrt.FeedMessage {
+	msg := util.MakeFeedMessage(latestTimestamp(c.SyncTime))
+	msg.Entity = make([]*gtfsrt.FeedEntity, 0, len(c.SyncTime))
+
+	for vID, v := range c.SyncTime {
+		entity := tripUpdateFor(v, c.PrevVehicles[vID], gtfsFile, c.Extrapolator)
+		if entity != nil {
+			msg.Entity = append(msg.Entity, entity)
+		}
+	}
+
+	return msg
+}
+
+// SavePB marshals the container into a GTFS-Realtime protocol buffer file
+func (c *Container) SavePB(target string, gtfsFile *gtfs.Gtfs, humanReadable bool) (err error) {
+	f, err := os.Create(target)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if humanReadable {
+		return proto.MarshalText(f, c.AsProto(gtfsFile))
+	}
+
+	b, err := proto.Marshal(c.AsProto(gtfsFile))
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	return
+}
+
+// SaveJSON marshals the trip updates into a human-readable JSON file
+func (c *Container) SaveJSON(target string, gtfsFile *gtfs.Gtfs) error {
+	updates := make([]*jsonTripUpdate, 0, len(c.SyncTime))
+	for vID, v := range c.SyncTime {
+		if u := jsonTripUpdateFor(v, c.PrevVehicles[vID], gtfsFile, c.Extrapolator); u != nil {
+			updates = append(updates, u)
+		}
+	}
+	return saveJSON(target, updates)
+}
+
+// gtfsResource wraps a util.Fetcher that reloads the full GTFS (stops, trips - including
+// each trip's StopTimes - and shapes) whenever the underlying source changes. tripupdates
+// needs more of the GTFS than positions.brigadesResource keeps around, so it maintains its
+// own copy rather than reaching into that package's unexported state.
+type gtfsResource struct {
+	Fetcher *util.Fetcher[*gtfs.Gtfs]
+	File    *gtfs.Gtfs
+}
+
+// newGtfsResource builds a gtfsResource polling source no more often than checkPeriod
+func newGtfsResource(source util.FetchSource, checkPeriod time.Duration) *gtfsResource {
+	gr := &gtfsResource{}
+	gr.Fetcher = &util.Fetcher[*gtfs.Gtfs]{
+		Source: source,
+		Period: checkPeriod,
+		Decode: func(raw []byte) (*gtfs.Gtfs, error) {
+			gtfsFile, err := gtfs.NewGtfsFromReaderAt(bytes.NewReader(raw), int64(len(raw)))
+			if err != nil {
+				return nil, err
+			}
+			defer gtfsFile.Close()
+
+			if err := gtfsFile.LoadAll(); err != nil {
+				return nil, err
+			}
+			return gtfsFile, nil
+		},
+		OnUpdate: func(gtfsFile *gtfs.Gtfs) error {
+			gr.File = gtfsFile
+			return nil
+		},
+	}
+	return gr
+}
+
+// Update reloads the GTFS if the source has changed, or if nothing has been loaded yet
+func (gr *gtfsResource) Update(ctx context.Context) error {
+	_, err := gr.Fetcher.Poll(ctx, gr.File == nil)
+	return err
+}
+
+// Loop runs a combined positions+tripupdates update loop: it reuses positions.LoopContext
+// to poll gtfsSource, fetch and match vehicles, and write the positions feed, and rebuilds
+// the trip updates feed from that same match pass via posOpts.PostUpdate. It's a thin
+// wrapper around LoopContext using context.Background().
+func Loop(client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, posOpts positions.Options, opts Options) error {
+	return LoopContext(context.Background(), client, gtfsSource, checkPeriod, sleepTime, posOpts, opts)
+}
+
+// LoopContext is Loop with a caller-provided context. Cancelling ctx stops the loop
+// (returning ctx.Err()) instead of waiting out the current sleep or backoff.
+func LoopContext(ctx context.Context, client *http.Client, gtfsSource util.FetchSource, checkPeriod, sleepTime time.Duration, posOpts positions.Options, opts Options) error {
+	gr := newGtfsResource(gtfsSource, checkPeriod)
+	var prevVehicles map[string]*positions.Vehicle
+
+	posOpts.PostUpdate = func(vehicles map[string]*positions.Vehicle) error {
+		if err := gr.Update(ctx); err != nil {
+			return err
+		}
+
+		err := Create(vehicles, prevVehicles, gr.File, opts)
+		prevVehicles = vehicles
+		return err
+	}
+
+	return positions.LoopContext(ctx, client, gtfsSource, checkPeriod, sleepTime, posOpts)
+}
@@ -0,0 +1,152 @@
+package tripupdates
+
+import (
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// defaultFallbackSpeedKmh is used by ShapeSpeedExtrapolator when no running speed can be
+// derived, a typical scheduled-average including dwell time at stops for Warsaw's surface
+// routes - trams and buses are usually slower, but so is guessing a route-specific figure
+// from nothing.
+const defaultFallbackSpeedKmh = 20.0
+
+// minMeasurableSpeedKmh is the smallest speed ShapeSpeedExtrapolator trusts as "the vehicle
+// is moving" - below this (e.g. stuck in traffic, or barely any distance/time between
+// updates) FallbackSpeedKmh is used instead, since a near-zero measured speed would make the
+// ETA blow up to nonsense.
+const minMeasurableSpeedKmh = 2.0
+
+// ShapeSpeedExtrapolator predicts arrival times by projecting the vehicle and every
+// downstream stop onto the trip's shape - the cumulative distance travelled along
+// shapes.txt's point sequence - and dividing the remaining distance by an average speed.
+// That speed is, preferably, the vehicle's own recent running speed (distance covered since
+// prev, divided by elapsed time); FallbackSpeedKmh is used instead whenever there's no prev
+// to measure against, or the measured speed is implausibly low. A trip with no shape loaded
+// is handed to LinearExtrapolator instead, so no speed - measured or fallback - is used for it.
+type ShapeSpeedExtrapolator struct {
+	// FallbackSpeedKmh is the constant speed assumed when a running speed can't be derived.
+	// Defaults to defaultFallbackSpeedKmh (20 km/h) when left zero.
+	FallbackSpeedKmh float64
+}
+
+func (e ShapeSpeedExtrapolator) fallbackSpeedKmh() float64 {
+	if e.FallbackSpeedKmh > 0 {
+		return e.FallbackSpeedKmh
+	}
+	return defaultFallbackSpeedKmh
+}
+
+// Extrapolate implements Extrapolator
+func (e ShapeSpeedExtrapolator) Extrapolate(trip gtfs.TripData, gtfsFile *gtfs.Gtfs, v, prev *positions.Vehicle) []*gtfsrt.TripUpdate_StopTimeUpdate {
+	if len(trip.StopTimes) == 0 {
+		return nil
+	}
+
+	shape := gtfsFile.Shapes[trip.ShapeID]
+	if len(shape) < 2 {
+		return LinearExtrapolator{}.Extrapolate(trip, gtfsFile, v, prev)
+	}
+	cum := shapeCumulativeDistances(shape)
+
+	vehicleDist := projectOntoShape(shape, cum, v.Lat, v.Lon)
+	speedKmh := e.runningSpeedKmh(shape, cum, vehicleDist, v, prev)
+
+	// Same as LinearExtrapolator: only emit updates for stops at or after the vehicle's
+	// current position, not every stop_time in the trip - an already-passed stop has nothing
+	// left to predict.
+	refIdx := nearestStopTime(trip.StopTimes, gtfsFile.Stops, v.Lat, v.Lon)
+
+	now := secondsSinceMidnight(v.TimeObj)
+	updates := make([]*gtfsrt.TripUpdate_StopTimeUpdate, 0, len(trip.StopTimes)-refIdx)
+	predictedPrev := now
+
+	for _, st := range trip.StopTimes[refIdx:] {
+		stopPos, ok := gtfsFile.Stops[st.StopID]
+		if !ok {
+			continue
+		}
+
+		scheduled, err := util.ParseTimeToSeconds(st.Timepoint)
+		if err != nil {
+			continue
+		}
+
+		stopDist := projectOntoShape(shape, cum, stopPos[0], stopPos[1])
+		remainingKm := stopDist - vehicleDist
+		if remainingKm < 0 {
+			remainingKm = 0
+		}
+
+		predicted := now + int(remainingKm/speedKmh*3600)
+		if predicted < predictedPrev {
+			predicted = predictedPrev
+		}
+		predictedPrev = predicted
+
+		d := int32(predicted) - int32(scheduled)
+		stopID, stopSeq := st.StopID, uint32(st.Sequence)
+		updates = append(updates, &gtfsrt.TripUpdate_StopTimeUpdate{
+			StopSequence: &stopSeq,
+			StopId:       &stopID,
+			Arrival:      &gtfsrt.TripUpdate_StopTimeEvent{Delay: &d},
+			Departure:    &gtfsrt.TripUpdate_StopTimeEvent{Delay: &d},
+		})
+	}
+
+	return updates
+}
+
+// runningSpeedKmh derives the vehicle's current speed along shape from prev's own projected
+// distance and the elapsed time since prev, falling back to e.fallbackSpeedKmh() whenever
+// that measurement isn't available or isn't trustworthy.
+func (e ShapeSpeedExtrapolator) runningSpeedKmh(shape []gtfs.ShapePoint, cum []float64, vehicleDist float64, v, prev *positions.Vehicle) float64 {
+	if prev == nil {
+		return e.fallbackSpeedKmh()
+	}
+
+	elapsedH := v.TimeObj.Sub(prev.TimeObj).Hours()
+	if elapsedH <= 0 {
+		return e.fallbackSpeedKmh()
+	}
+
+	prevDist := projectOntoShape(shape, cum, prev.Lat, prev.Lon)
+	distKm := vehicleDist - prevDist
+	if distKm <= 0 {
+		return e.fallbackSpeedKmh()
+	}
+
+	speed := distKm / elapsedH
+	if speed < minMeasurableSpeedKmh {
+		return e.fallbackSpeedKmh()
+	}
+	return speed
+}
+
+// shapeCumulativeDistances returns, for every point in shape, the great-circle distance (km)
+// travelled from shape[0] up to and including that point.
+func shapeCumulativeDistances(shape []gtfs.ShapePoint) []float64 {
+	cum := make([]float64, len(shape))
+	for i := 1; i < len(shape); i++ {
+		cum[i] = cum[i-1] + haversine(shape[i-1].Lat, shape[i-1].Lon, shape[i].Lat, shape[i].Lon)
+	}
+	return cum
+}
+
+// projectOntoShape approximates how far along shape (lat, lon) lies, as the cumulative
+// distance of whichever shape point is nearest to it by great-circle distance. This is
+// a nearest-vertex approximation rather than a true point-to-segment projection, but shapes
+// are usually dense enough (a point every few tens of meters) for the difference not to
+// matter for an ETA estimate.
+func projectOntoShape(shape []gtfs.ShapePoint, cum []float64, lat, lon float64) float64 {
+	best := 0
+	bestDist := -1.0
+	for i, p := range shape {
+		if d := haversine(lat, lon, p.Lat, p.Lon); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return cum[best]
+}
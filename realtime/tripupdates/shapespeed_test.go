@@ -0,0 +1,97 @@
+package tripupdates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+)
+
+func TestShapeSpeedExtrapolatorUsesRunningSpeed(t *testing.T) {
+	trip := gtfs.TripData{
+		ShapeID: "S1",
+		StopTimes: []gtfs.StopTime{
+			{StopID: "A", Timepoint: "10:00", Sequence: 0},
+			{StopID: "B", Timepoint: "10:05", Sequence: 1},
+			{StopID: "C", Timepoint: "10:10", Sequence: 2},
+		},
+	}
+	gtfsFile := &gtfs.Gtfs{
+		Stops: map[string][2]float64{
+			"A": {52.00, 21.00},
+			"B": {52.10, 21.00},
+			"C": {52.20, 21.00},
+		},
+		Shapes: map[string][]gtfs.ShapePoint{
+			"S1": {
+				{Lat: 52.00, Lon: 21.00, Sequence: 0},
+				{Lat: 52.10, Lon: 21.00, Sequence: 1},
+				{Lat: 52.20, Lon: 21.00, Sequence: 2},
+			},
+		},
+	}
+
+	prev := &positions.Vehicle{
+		Lat:     52.00,
+		Lon:     21.00,
+		TimeObj: time.Date(2023, 5, 17, 10, 0, 0, 0, util.WarsawTimezone),
+	}
+	// 10 minutes later the vehicle reached B, ~11 km along the shape, so it's running at
+	// ~67 km/h, well above the fallback speed - the predicted arrival at C should be derived
+	// from that measured speed, not the fallback, and never before the already-predicted
+	// arrival at B.
+	v := &positions.Vehicle{
+		Lat:     52.10,
+		Lon:     21.00,
+		TimeObj: time.Date(2023, 5, 17, 10, 10, 0, 0, util.WarsawTimezone),
+	}
+
+	updates := ShapeSpeedExtrapolator{}.Extrapolate(trip, gtfsFile, v, prev)
+
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2 (B and C only, A already passed)", len(updates))
+	}
+	if got := updates[0].GetStopId(); got != "B" {
+		t.Errorf("updates[0].StopId = %q, want B", got)
+	}
+	// B->C is another ~11 km: ~600s at the measured speed, ~2000s at the 20 km/h fallback
+	if got := updates[1].GetArrival().GetDelay(); got < 590 || got > 610 {
+		t.Errorf("updates[1] delay = %d, want ~600 (from the measured running speed)", got)
+	}
+	for i := 1; i < len(updates); i++ {
+		if updates[i].GetArrival().GetDelay() < updates[i-1].GetArrival().GetDelay()-int32(300) {
+			// Each stop is 5 scheduled minutes apart; the predicted time must never
+			// regress relative to the previous stop's prediction.
+			t.Errorf("updates[%d] delay %d regresses before updates[%d] delay %d", i, updates[i].GetArrival().GetDelay(), i-1, updates[i-1].GetArrival().GetDelay())
+		}
+	}
+}
+
+func TestShapeSpeedExtrapolatorFallsBackWithoutShape(t *testing.T) {
+	trip := gtfs.TripData{
+		ShapeID: "missing",
+		StopTimes: []gtfs.StopTime{
+			{StopID: "A", Timepoint: "10:00", Sequence: 0},
+			{StopID: "B", Timepoint: "10:05", Sequence: 1},
+		},
+	}
+	gtfsFile := &gtfs.Gtfs{
+		Stops: map[string][2]float64{
+			"A": {52.00, 21.00},
+			"B": {52.10, 21.00},
+		},
+		Shapes: map[string][]gtfs.ShapePoint{},
+	}
+	v := &positions.Vehicle{
+		Lat:     52.00,
+		Lon:     21.00,
+		TimeObj: time.Date(2023, 5, 17, 10, 0, 0, 0, util.WarsawTimezone),
+	}
+
+	updates := ShapeSpeedExtrapolator{}.Extrapolate(trip, gtfsFile, v, nil)
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2 (falls back to LinearExtrapolator when no shape loaded)", len(updates))
+	}
+}
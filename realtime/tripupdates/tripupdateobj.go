@@ -0,0 +1,136 @@
+package tripupdates
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/util"
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// tripUpdateFor builds a single GTFS-Realtime FeedEntity for a matched vehicle. When the
+// trip's full StopTimes were loaded (see gtfs.TripData.StopTimes), extrapolator produces a
+// per-stop delay estimate for every downstream stop; when it produces none, this falls back
+// to a single entry at the trip's terminal stop, as before. prevV is the same vehicle as of
+// the previous update, or nil - it's only used by extrapolator. Returns nil when the
+// vehicle's trip is unknown to the loaded GTFS or has no stop_times loaded at all; the
+// terminal-stop entry carries no delay while the vehicle isn't near that stop.
+func tripUpdateFor(v, prevV *positions.Vehicle, gtfsFile *gtfs.Gtfs, extrapolator Extrapolator) *gtfsrt.FeedEntity {
+	trip, ok := gtfsFile.Trips[v.Trip]
+	if !ok {
+		return nil
+	}
+
+	var updates []*gtfsrt.TripUpdate_StopTimeUpdate
+	if len(trip.StopTimes) > 0 {
+		updates = extrapolator.Extrapolate(trip, gtfsFile, v, prevV)
+	}
+	if len(updates) == 0 {
+		updates = terminusStopTimeUpdate(v, trip, gtfsFile)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	id := "TU/" + v.Trip
+	tstamp := uint64(v.TimeObj.Unix())
+
+	return &gtfsrt.FeedEntity{
+		Id: &id,
+		TripUpdate: &gtfsrt.TripUpdate{
+			// TripDescriptor has no wheelchair_accessible/bikes_allowed fields in
+			// GTFS-Realtime - those are GTFS static trips.txt concepts - so the matched
+			// vehicle's Capabilities travel the same way VehiclePosition carries them: as
+			// a suffix on VehicleDescriptor.Label (see positions.labelWithCapabilities).
+			Trip:           &gtfsrt.TripDescriptor{TripId: &v.Trip},
+			Vehicle:        &gtfsrt.VehicleDescriptor{Id: &v.ID, Label: proto.String(v.CapabilitiesLabel())},
+			Timestamp:      &tstamp,
+			StopTimeUpdate: updates,
+		},
+	}
+}
+
+// terminusStopTimeUpdate is the pre-Extrapolator behaviour, kept as a fallback for when the
+// Extrapolator returns no updates: a single delay estimate at the trip's terminal stop,
+// trusted only once the vehicle is actually near that stop - otherwise the vehicle is
+// still mid-trip and its current timestamp says nothing about when it'll reach it, and the
+// entry carries no delay. Returns nil when the trip has no LastStopTime.
+func terminusStopTimeUpdate(v *positions.Vehicle, trip gtfs.TripData, gtfsFile *gtfs.Gtfs) []*gtfsrt.TripUpdate_StopTimeUpdate {
+	if trip.LastStopTime.Timepoint == "" {
+		return nil
+	}
+
+	scheduledSeconds, err := util.ParseTimeToSeconds(trip.LastStopTime.Timepoint)
+	if err != nil {
+		return nil
+	}
+
+	var delay *int32
+	if stopPos, hasStopPos := gtfsFile.Stops[trip.LastStopTime.StopID]; hasStopPos {
+		if haversine(v.Lat, v.Lon, stopPos[0], stopPos[1]) <= terminusGateKm {
+			d := int32(secondsSinceMidnight(v.TimeObj)) - int32(scheduledSeconds)
+			delay = &d
+		}
+	}
+
+	stopID := trip.LastStopTime.StopID
+	stopSeq := uint32(trip.LastStopTime.Sequence)
+
+	return []*gtfsrt.TripUpdate_StopTimeUpdate{
+		{
+			StopSequence: &stopSeq,
+			StopId:       &stopID,
+			Arrival:      &gtfsrt.TripUpdate_StopTimeEvent{Delay: delay},
+			Departure:    &gtfsrt.TripUpdate_StopTimeEvent{Delay: delay},
+		},
+	}
+}
+
+// jsonTripUpdate is the human-readable sidecar representation of a single TripUpdate
+type jsonTripUpdate struct {
+	TripID    string `json:"trip_id"`
+	VehicleID string `json:"vehicle_id"`
+	StopID    string `json:"stop_id"`
+	Timestamp string `json:"timestamp"`
+	Delay     *int32 `json:"delay_seconds,omitempty"`
+}
+
+func jsonTripUpdateFor(v, prevV *positions.Vehicle, gtfsFile *gtfs.Gtfs, extrapolator Extrapolator) *jsonTripUpdate {
+	entity := tripUpdateFor(v, prevV, gtfsFile, extrapolator)
+	if entity == nil {
+		return nil
+	}
+
+	ste := entity.TripUpdate.StopTimeUpdate[0]
+	return &jsonTripUpdate{
+		TripID:    v.Trip,
+		VehicleID: v.ID,
+		StopID:    ste.GetStopId(),
+		Timestamp: v.TimeObj.Format("2006-01-02T15:04:05"),
+		Delay:     ste.GetArrival().Delay,
+	}
+}
+
+func saveJSON(target string, updates []*jsonTripUpdate) error {
+	b, err := json.MarshalIndent(updates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(target, b, 0o666)
+}
+
+// latestTimestamp returns the newest TimeObj among the matched vehicles, falling back to
+// the current time when there are none.
+func latestTimestamp(vehicles map[string]*positions.Vehicle) time.Time {
+	latest := time.Now()
+	for _, v := range vehicles {
+		if v.TimeObj.After(latest) {
+			latest = v.TimeObj
+		}
+	}
+	return latest
+}
@@ -0,0 +1,27 @@
+package util
+
+import "time"
+
+// WarsawTimezone is the IANA location used to interpret service dates and
+// schedule times throughout the realtime module. All GTFS timepoints and
+// calendar_dates.txt entries are expressed in local Warsaw time.
+var WarsawTimezone *time.Location = mustLoadWarsawTimezone()
+
+func mustLoadWarsawTimezone() *time.Location {
+	loc, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		panic("util: failed to load Europe/Warsaw timezone: " + err.Error())
+	}
+	return loc
+}
+
+// ServiceDate returns the GTFS calendar_dates.txt "date" (YYYYMMDD) representing
+// the service day t belongs to. GTFS service days can extend past midnight
+// (e.g. a night bus departing at 25:30), so times before 4 AM are considered
+// part of the previous service day.
+func ServiceDate(t time.Time) string {
+	if t.Hour() < 4 {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t.Format("20060102")
+}
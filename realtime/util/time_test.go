@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceDate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   time.Time
+		want string
+	}{
+		{"midday", time.Date(2023, 5, 17, 13, 0, 0, 0, WarsawTimezone), "20230517"},
+		{"just before the 4 AM cutoff", time.Date(2023, 5, 17, 3, 59, 59, 0, WarsawTimezone), "20230516"},
+		{"exactly at the 4 AM cutoff", time.Date(2023, 5, 17, 4, 0, 0, 0, WarsawTimezone), "20230517"},
+		{"just after midnight", time.Date(2023, 5, 17, 0, 0, 1, 0, WarsawTimezone), "20230516"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ServiceDate(tt.in); got != tt.want {
+				t.Errorf("ServiceDate(%v) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
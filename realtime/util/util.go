@@ -8,6 +8,7 @@ import (
 	"time"
 
 	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/golang/protobuf/proto"
 )
 
 // MakeTranslatedString takes a string and warps it into a gtfs-realtime TranslatedString object
@@ -19,6 +20,25 @@ func MakeTranslatedString(s string) *gtfsrt.TranslatedString {
 	}
 }
 
+// MakeTranslatedStringMap wraps translations keyed by BCP-47 language tag into a
+// gtfs-realtime TranslatedString, with one Translation per entry. Languages are
+// sorted to keep the output deterministic.
+func MakeTranslatedStringMap(m map[string]string) *gtfsrt.TranslatedString {
+	langs := make([]string, 0, len(m))
+	for lang := range m {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	translations := make([]*gtfsrt.TranslatedString_Translation, len(langs))
+	for i, lang := range langs {
+		text := m[lang]
+		translations[i] = &gtfsrt.TranslatedString_Translation{Text: &text, Language: &lang}
+	}
+
+	return &gtfsrt.TranslatedString{Translation: translations}
+}
+
 // MakeFeedMessage preapres a GTFS-RT FeedMessage object and adds a valid FeedHeader to it
 func MakeFeedMessage(t time.Time) *gtfsrt.FeedMessage {
 	ver := "2.0"
@@ -33,6 +53,44 @@ func MakeFeedMessage(t time.Time) *gtfsrt.FeedMessage {
 	}
 }
 
+// FeedDiffer builds a DIFFERENTIAL FeedMessage out of prev and next, two FULL_DATASET
+// FeedMessages sharing the same entity ID scheme (e.g. successive VehicleContainer.AsProto
+// outputs). Entities that are new or changed since prev are included as-is; entities
+// present in prev but missing from next are included with IsDeleted=true; entities
+// that didn't change at all are dropped, since a differential consumer already has them.
+func FeedDiffer(prev, next *gtfsrt.FeedMessage) *gtfsrt.FeedMessage {
+	incr := gtfsrt.FeedHeader_DIFFERENTIAL
+	diff := &gtfsrt.FeedMessage{
+		Header: &gtfsrt.FeedHeader{
+			GtfsRealtimeVersion: next.Header.GtfsRealtimeVersion,
+			Incrementality:      &incr,
+			Timestamp:           next.Header.Timestamp,
+		},
+	}
+
+	prevByID := make(map[string]*gtfsrt.FeedEntity, len(prev.GetEntity()))
+	for _, e := range prev.GetEntity() {
+		prevByID[e.GetId()] = e
+	}
+
+	seen := make(map[string]bool, len(next.GetEntity()))
+	for _, e := range next.GetEntity() {
+		seen[e.GetId()] = true
+		if old, ok := prevByID[e.GetId()]; !ok || !proto.Equal(old, e) {
+			diff.Entity = append(diff.Entity, e)
+		}
+	}
+
+	for id, e := range prevByID {
+		if !seen[id] {
+			isDeleted := true
+			diff.Entity = append(diff.Entity, &gtfsrt.FeedEntity{Id: e.Id, IsDeleted: &isDeleted})
+		}
+	}
+
+	return diff
+}
+
 // StringSliceHas checks if element is inside a StringSlice
 func StringSliceHas(s sort.StringSlice, x string) bool {
 	maxIdx := s.Len()
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"testing"
+
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+func entity(id string, lat float32) *gtfsrt.FeedEntity {
+	return &gtfsrt.FeedEntity{
+		Id:      &id,
+		Vehicle: &gtfsrt.VehiclePosition{Position: &gtfsrt.Position{Latitude: &lat}},
+	}
+}
+
+func entityIDs(entities []*gtfsrt.FeedEntity) map[string]bool {
+	ids := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		ids[e.GetId()] = true
+	}
+	return ids
+}
+
+func TestFeedDiffer(t *testing.T) {
+	prev := &gtfsrt.FeedMessage{
+		Entity: []*gtfsrt.FeedEntity{
+			entity("unchanged", 1),
+			entity("changed", 1),
+			entity("removed", 1),
+		},
+	}
+	next := &gtfsrt.FeedMessage{
+		Header: &gtfsrt.FeedHeader{},
+		Entity: []*gtfsrt.FeedEntity{
+			entity("unchanged", 1),
+			entity("changed", 2),
+			entity("added", 1),
+		},
+	}
+
+	diff := FeedDiffer(prev, next)
+
+	if got := diff.Header.GetIncrementality(); got != gtfsrt.FeedHeader_DIFFERENTIAL {
+		t.Errorf("Header.Incrementality = %v, want DIFFERENTIAL", got)
+	}
+
+	ids := entityIDs(diff.Entity)
+	if ids["unchanged"] {
+		t.Error("unchanged entity should have been dropped from the diff")
+	}
+	if !ids["changed"] {
+		t.Error("changed entity is missing from the diff")
+	}
+	if !ids["added"] {
+		t.Error("added entity is missing from the diff")
+	}
+	if !ids["removed"] {
+		t.Error("removed entity is missing from the diff")
+	}
+
+	for _, e := range diff.Entity {
+		if e.GetId() == "removed" && !e.GetIsDeleted() {
+			t.Error("removed entity should be marked IsDeleted")
+		}
+	}
+}
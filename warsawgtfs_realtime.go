@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
@@ -14,7 +15,10 @@ import (
 
 	"github.com/MKuranowski/WarsawGTFS/realtime/alerts"
 	"github.com/MKuranowski/WarsawGTFS/realtime/gtfs"
+	"github.com/MKuranowski/WarsawGTFS/realtime/logging"
 	"github.com/MKuranowski/WarsawGTFS/realtime/positions"
+	"github.com/MKuranowski/WarsawGTFS/realtime/server"
+	"github.com/MKuranowski/WarsawGTFS/realtime/tripupdates"
 	"github.com/MKuranowski/WarsawGTFS/realtime/util"
 )
 
@@ -39,6 +43,80 @@ var (
 		false,
 		"create GTFS-Realtime vehicle positions")
 
+	flagTripUpdates = flag.Bool(
+		"t",
+		false,
+		"also create a GTFS-Realtime trip updates feed from the matched vehicles (requires -p or -server)")
+
+	flagServer = flag.Bool(
+		"server",
+		false,
+		"instead of writing feed files to -target, serve alerts/positions/trip updates directly\n"+
+			"over HTTP (see realtime/server); runs alerts and positions (with -t, trip updates\n"+
+			"too) in a loop, so requires -loop")
+
+	flagListenAddr = flag.String(
+		"listen",
+		":8080",
+		"for -server: address to listen on")
+
+	flagAdapterScript = flag.String(
+		"adapter-script",
+		"",
+		"for positions: path to a Lua script (see realtime/positions.LuaAdapter) providing\n"+
+			"a fetch()/parse()/match() feed in place of the built-in Warsaw adapter")
+
+	flagProvider = flag.String(
+		"provider",
+		"warsaw",
+		"for positions: vehicle position source feeding the built-in adapter - \"warsaw\"\n"+
+			"(api.um.warszawa.pl, the default), \"gtfs-rt\" or \"siri-vm\" (see\n"+
+			"realtime/positions.ProviderFactories); ignored when -adapter-script is set")
+
+	flagProviderURL = flag.String(
+		"provider-url",
+		"",
+		"for positions: upstream feed URL for -provider \"gtfs-rt\"/\"siri-vm\"")
+
+	flagAlertBackendScript = flag.String(
+		"alert-backend-script",
+		"",
+		"for alerts: path to a Lua script (see realtime/alerts.LuaBackend) providing a\n"+
+			"list_alerts()/enrich_alert() source, scraped alongside the built-in wtp.waw.pl one")
+
+	flagSmooth = flag.Bool(
+		"smooth",
+		false,
+		"for positions: Kalman-filter vehicle positions and map-match them onto GTFS shapes,\n"+
+			"carrying filter state between runs in a kalman.json file in -target")
+
+	flagDiff = flag.Bool(
+		"diff",
+		false,
+		"for positions: also write a DIFFERENTIAL GTFS-RT feed (positions-diff.pb in -target)\n"+
+			"against the full feed from the previous run")
+
+	flagDiffFullEvery = flag.Int(
+		"diff-full-every",
+		10,
+		"for positions: with -diff, how many differential updates run between full resyncs\n"+
+			"of positions-diff.pb; 0 disables the cadence and always diffs against -target")
+
+	flagStoreFile = flag.String(
+		"store",
+		"",
+		"for positions: path to a SQLite database (see realtime/positions.SQLiteStore) used\n"+
+			"to persist matched vehicles and brigade maps across restarts; omit to keep loop\n"+
+			"state in memory only")
+
+	flagVehicleDB = flag.String(
+		"vehicle-db",
+		"",
+		"for positions: path/URL to a JSON or CSV side-file (see realtime/positions.VehicleDB)\n"+
+			"mapping vehicle side numbers to capabilities (wheelchair accessible, low floor,\n"+
+			"air conditioning, bikes allowed, USB charging); vehicles missing from it fall back\n"+
+			"to a numeric-range guess (see positions.FleetRangeCapabilities)")
+
 	// Input options
 	flagApikey = flag.String(
 		"k",
@@ -72,6 +150,30 @@ var (
 		"for alerts: any errors when scraping wtp.waw.pl will become fatal,\n"+
 			"for brigades: any ignorable data mismatch will become fatal")
 
+	flagLanguages = flag.String(
+		"languages",
+		"pl",
+		"for alerts: comma-separated BCP-47 language tags to fetch translations for;\n"+
+			"pl is always scraped as the default, untranslated language, listed or not")
+
+	flagTranslateURL = flag.String(
+		"translate-url",
+		"",
+		"for alerts: URL of a LibreTranslate/DeepL-compatible HTTP endpoint, used to\n"+
+			"auto-translate languages wtp.waw.pl has no native page for; omit to only use\n"+
+			"-translate-glossary, if given, or skip such languages entirely")
+
+	flagTranslateKey = flag.String(
+		"translate-key",
+		"",
+		"for alerts: api_key sent to -translate-url, if it requires one")
+
+	flagTranslateGlossary = flag.String(
+		"translate-glossary",
+		"",
+		"for alerts: path to a YAML file overriding fixed phrases (e.g. stop names) in\n"+
+			"-translate-url's output, keyed by target language (see alerts.GlossaryTranslator)")
+
 	// Loop options
 	flagLoop = flag.Duration(
 		"loop",
@@ -83,8 +185,73 @@ var (
 		"checkdata",
 		30*time.Minute,
 		"how often check if the -gtfs-file has changed")
+
+	// Logging options
+	flagLogFormat = flag.String(
+		"log-format",
+		"text",
+		"log output format: \"text\" or \"json\" (see realtime/logging)")
+
+	flagLogLevel = flag.String(
+		"log-level",
+		"info",
+		"minimum log level to emit: \"debug\", \"info\", \"warn\" or \"error\"")
+
+	flagLogProgress = flag.Bool(
+		"log-progress",
+		false,
+		"overwrite the previous line for transient progress updates (e.g. brigade matching\n"+
+			"counters) instead of logging one line per update; only takes effect when stderr\n"+
+			"is an interactive terminal, so it's always safe under systemd/journald or when\n"+
+			"redirected to a file")
+
+	// flagUpstreamFeeds collects -upstream-feed occurrences; registered below, outside this
+	// var block, since flag.Var returns nothing and can't itself be assigned to a var here.
+	flagUpstreamFeeds upstreamFeedFlags
 )
 
+func init() {
+	flag.Var(
+		&flagUpstreamFeeds,
+		"upstream-feed",
+		"for positions: url[,trip_id_prefix[,route_id_prefix]] of an already-published\n"+
+			"GTFS-RT VehiclePositions feed (see realtime/positions.UpstreamSource) to merge\n"+
+			"into the output; may be repeated")
+}
+
+// upstreamFeedFlags accumulates repeated -upstream-feed flag occurrences, each encoding a
+// single positions.UpstreamSource as "url[,trip_id_prefix[,route_id_prefix]]".
+type upstreamFeedFlags []upstreamFeedFlag
+
+type upstreamFeedFlag struct {
+	URL                         string
+	TripIDPrefix, RouteIDPrefix string
+}
+
+func (f *upstreamFeedFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	parts := make([]string, len(*f))
+	for i, u := range *f {
+		parts[i] = u.URL
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *upstreamFeedFlags) Set(value string) error {
+	parts := strings.SplitN(value, ",", 3)
+	u := upstreamFeedFlag{URL: parts[0]}
+	if len(parts) > 1 {
+		u.TripIDPrefix = parts[1]
+	}
+	if len(parts) > 2 {
+		u.RouteIDPrefix = parts[2]
+	}
+	*f = append(*f, u)
+	return nil
+}
+
 /* ================
    FLAG PROCESSING
   ================= */
@@ -107,9 +274,23 @@ func checkModes() error {
 		modeCount++
 	}
 
+	// -server runs alerts and positions together, so it doesn't take -a/-p itself
+	if *flagServer {
+		if modeCount != 0 {
+			return errors.New("-server can't be combined with -a or -p")
+		}
+		if *flagLoop <= 0 {
+			return errors.New("-server requires -loop to be a positive duration")
+		}
+		return nil
+	}
+
 	if modeCount != 1 {
 		return errors.New("exactly one of the -a or -p flags has to be provided")
 	}
+	if *flagTripUpdates && !*flagPositions {
+		return errors.New("the -t flag requires -p")
+	}
 	return nil
 }
 
@@ -118,29 +299,112 @@ func parseAlertsFlags() (o alerts.Options, err error) {
 	o.GtfsRtTarget = path.Join(*flagTarget, "alerts.pb")
 	o.HumanReadable = *flagReadable
 	o.ThrowLinkErrors = *flagStrict
+	o.Languages = strings.Split(*flagLanguages, ",")
 
 	if *flagJSON {
 		o.JSONTarget = path.Join(*flagTarget, "alerts.json")
 	}
 
+	// Build the translation function used to fill in languages wtp.waw.pl has no native
+	// page for, out of whichever of -translate-url/-translate-glossary were given
+	var glossary *alerts.GlossaryTranslator
+	if *flagTranslateGlossary != "" {
+		glossary, err = alerts.LoadGlossaryTranslator(*flagTranslateGlossary)
+		if err != nil {
+			return
+		}
+	}
+	var httpTranslator *alerts.HTTPTranslator
+	if *flagTranslateURL != "" {
+		httpTranslator = alerts.NewHTTPTranslator(client, *flagTranslateURL, *flagTranslateKey)
+	}
+	if glossary != nil || httpTranslator != nil {
+		o.Translate = alerts.NewTranslateFunc(glossary, httpTranslator)
+	}
+
+	// An extra Lua-scripted backend runs alongside the built-in wtp.waw.pl one
+	if *flagAlertBackendScript != "" {
+		var luaBackend *alerts.LuaBackend
+		luaBackend, err = alerts.NewLuaBackend(*flagAlertBackendScript, client)
+		if err != nil {
+			return
+		}
+		o.Backends = []alerts.Backend{alerts.NewWtpBackend(client, o.Translate), luaBackend}
+	}
+
 	return
 }
 
 // parsePositionsFlags parses flags to positions.Options
 func parsePositionsFlags() (o positions.Options, err error) {
-	// Ensure an apikey was provided
-	o.Apikey = getApikey()
-	if o.Apikey == "" {
-		err = errors.New("key for api.um.warszawa.pl needs to be provided")
-		return
+	switch {
+	case *flagAdapterScript != "":
+		// A Lua adapter fetches/parses/matches on its own, so it doesn't need an apikey
+		o.Adapter, err = positions.NewLuaAdapter(*flagAdapterScript, client)
+		if err != nil {
+			return
+		}
+
+	case *flagProvider != "warsaw":
+		// A non-default Provider still runs through WarsawAdapter's Parse/MatchTrip - only
+		// how the raw entries are fetched changes
+		factory, ok := positions.ProviderFactories[*flagProvider]
+		if !ok {
+			err = fmt.Errorf("unknown -provider %q", *flagProvider)
+			return
+		}
+		var provider positions.Provider
+		provider, err = factory(client, getApikey(), *flagProviderURL)
+		if err != nil {
+			return
+		}
+		o.Adapter = &positions.WarsawAdapter{Provider: provider}
+
+	default:
+		// Ensure an apikey was provided
+		o.Apikey = getApikey()
+		if o.Apikey == "" {
+			err = errors.New("key for api.um.warszawa.pl needs to be provided")
+			return
+		}
 	}
 
 	// Set options
 	o.GtfsRtTarget = path.Join(*flagTarget, "positions.pb")
 	o.HumanReadable = *flagReadable
+	o.ThrowAPIErrors = *flagStrict
 	if *flagJSON {
 		o.JSONTarget = path.Join(*flagTarget, "positions.json")
 	}
+	if *flagSmooth {
+		o.KalmanStateFile = path.Join(*flagTarget, "kalman.json")
+	}
+	if *flagDiff {
+		o.DiffGtfsRtTarget = path.Join(*flagTarget, "positions-diff.pb")
+		o.FullSnapshotEvery = *flagDiffFullEvery
+	}
+	for _, u := range flagUpstreamFeeds {
+		o.UpstreamSources = append(o.UpstreamSources, positions.NewUpstreamSource(client, u.URL, u.TripIDPrefix, u.RouteIDPrefix))
+	}
+	if *flagStoreFile != "" {
+		o.Store, err = positions.NewSQLiteStore(*flagStoreFile)
+		if err != nil {
+			return
+		}
+	}
+	if *flagVehicleDB != "" {
+		o.VehicleDB = positions.NewVehicleDB(wrapInResource(*flagVehicleDB), *flagDataCheck)
+	}
+	return
+}
+
+// parseTripUpdatesFlags parses flags to tripupdates.Options
+func parseTripUpdatesFlags() (o tripupdates.Options) {
+	o.GtfsRtTarget = path.Join(*flagTarget, "trip_updates.pb")
+	o.HumanReadable = *flagReadable
+	if *flagJSON {
+		o.JSONTarget = path.Join(*flagTarget, "trip_updates.json")
+	}
 	return
 }
 
@@ -151,7 +415,7 @@ func parsePositionsFlags() (o positions.Options, err error) {
 // loadGtfs creates a gtfs file from the provided argument and loads required data structures
 func loadGtfs(routesOnly bool) (gtfsFile *gtfs.Gtfs, err error) {
 	// retrieve the GTFS
-	log.Println("Retrieving provided GTFS")
+	logging.Info("retrieving provided GTFS", "source_url", *flagGtfsFile)
 	if strings.HasPrefix(*flagGtfsFile, "http://") || strings.HasPrefix(*flagGtfsFile, "https://") {
 		gtfsFile, err = gtfs.NewGtfsFromURL(*flagGtfsFile, client)
 	} else {
@@ -164,14 +428,14 @@ func loadGtfs(routesOnly bool) (gtfsFile *gtfs.Gtfs, err error) {
 
 	// Load data
 	if routesOnly {
-		log.Println("Loading routes.txt")
+		logging.Info("loading routes.txt")
 		if routesFile := gtfsFile.GetZipFileByName("routes.txt"); routesFile != nil {
 			err = gtfsFile.LoadRoutes(routesFile)
 		} else {
 			err = errors.New("no file routes.txt in the GTFS")
 		}
 	} else {
-		log.Println("Loading data from the GTFS")
+		logging.Info("loading data from the GTFS")
 		err = gtfsFile.LoadAll()
 	}
 
@@ -183,14 +447,12 @@ func loadGtfs(routesOnly bool) (gtfsFile *gtfs.Gtfs, err error) {
 	return
 }
 
-// wrapInResource wraps a "file" on local fs or on the internet inside a util.Resource
-func wrapInResource(source string) (res util.Resource) {
+// wrapInResource wraps a "file" on local fs or on the internet inside a util.FetchSource
+func wrapInResource(source string) (res util.FetchSource) {
 	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
-		res = &util.ResourceHTTP{
-			Client: client, URL: source, Period: *flagDataCheck,
-		}
+		res = &util.HTTPFetchSource{Client: client, URL: source}
 	} else {
-		res = &util.ResourceLocal{Path: source, Period: *flagDataCheck}
+		res = &util.FileFetchSource{Path: source}
 	}
 	return
 }
@@ -207,7 +469,7 @@ func loopAlerts() error {
 		return err
 	}
 	res := wrapInResource(*flagGtfsFile)
-	return alerts.Loop(client, res, *flagLoop, opts)
+	return alerts.Loop(client, res, *flagDataCheck, *flagLoop, opts)
 }
 
 // loopPositions prepares options for launching positions in a loop mode
@@ -218,7 +480,72 @@ func loopPositions() error {
 		return err
 	}
 	res := wrapInResource(*flagGtfsFile)
-	return positions.Loop(client, res, *flagLoop, opts)
+	return positions.Loop(client, res, *flagDataCheck, *flagLoop, opts)
+}
+
+// loopPositionsAndTripUpdates prepares options for launching both positions and their
+// companion trip updates feed in a loop mode, and then launches tripupdates.Loop
+func loopPositionsAndTripUpdates() error {
+	posOpts, err := parsePositionsFlags()
+	if err != nil {
+		return err
+	}
+	res := wrapInResource(*flagGtfsFile)
+	return tripupdates.Loop(client, res, *flagDataCheck, *flagLoop, posOpts, parseTripUpdatesFlags())
+}
+
+// runServer wires alerts/positions(/trip updates) loops to publish into a server.FeedStore
+// instead of writing files, and serves that store over HTTP on -listen until any of the
+// loops or the HTTP server itself fails.
+func runServer() error {
+	fs := &server.FeedStore{}
+
+	alertsOpts, err := parseAlertsFlags()
+	if err != nil {
+		return err
+	}
+	// Feeds are published only through fs, so no loop writes feed files to -target
+	alertsOpts.GtfsRtTarget, alertsOpts.JSONTarget = "", ""
+	alertsOpts.OnContainer = func(c *alerts.AlertContainer) error {
+		return fs.SetAlerts(c, *flagLoop)
+	}
+	alertsOpts.OnError = func(error) { fs.Metrics.RecordError("alerts") }
+
+	posOpts, err := parsePositionsFlags()
+	if err != nil {
+		return err
+	}
+	posOpts.GtfsRtTarget, posOpts.JSONTarget = "", ""
+	posOpts.OnContainer = func(c *positions.VehicleContainer) error {
+		return fs.SetPositions(c, *flagLoop)
+	}
+	posOpts.OnError = func(error) { fs.Metrics.RecordError("vehicle_positions") }
+
+	tripUpdatesOpts := parseTripUpdatesFlags()
+	tripUpdatesOpts.GtfsRtTarget, tripUpdatesOpts.JSONTarget = "", ""
+	tripUpdatesOpts.OnContainer = func(c *tripupdates.Container, gtfsFile *gtfs.Gtfs) error {
+		return fs.SetTripUpdates(c, gtfsFile, *flagLoop)
+	}
+
+	res := wrapInResource(*flagGtfsFile)
+	errCh := make(chan error, 3)
+
+	go func() { errCh <- alerts.Loop(client, res, *flagDataCheck, *flagLoop, alertsOpts) }()
+
+	go func() {
+		if *flagTripUpdates {
+			errCh <- tripupdates.Loop(client, res, *flagDataCheck, *flagLoop, posOpts, tripUpdatesOpts)
+		} else {
+			errCh <- positions.Loop(client, res, *flagDataCheck, *flagLoop, posOpts)
+		}
+	}()
+
+	go func() {
+		logging.Info("serving GTFS-Realtime feeds", "mode", "server", "listen", *flagListenAddr)
+		errCh <- http.ListenAndServe(*flagListenAddr, fs.Handler())
+	}()
+
+	return <-errCh
 }
 
 /* ============
@@ -242,7 +569,7 @@ func singleAlerts() error {
 	gtfsFile.Close()
 
 	// Make alerts
-	log.Println("Creating alerts")
+	logging.Info("creating alerts", "mode", "alerts")
 	return alerts.Make(client, gtfsFile.Routes, opts)
 }
 
@@ -262,8 +589,19 @@ func singlePositions() error {
 	gtfsFile.Close()
 
 	// Make positions
-	log.Println("Creating positions")
-	return positions.Main(client, gtfsFile, opts)
+	logging.Info("creating positions", "mode", "positions")
+	vehicles, err := positions.Main(client, gtfsFile, opts)
+	if err != nil {
+		return err
+	}
+
+	// Make the companion trip updates feed, reusing the vehicles positions just matched.
+	// There's no previous pass to compare against in single-pass mode.
+	if *flagTripUpdates {
+		logging.Info("creating trip updates", "mode", "trip_updates")
+		return tripupdates.Create(vehicles, nil, gtfsFile, parseTripUpdatesFlags())
+	}
+	return nil
 }
 
 /* ============
@@ -276,6 +614,7 @@ func main() {
 
 	// Parse CL flags
 	flag.Parse()
+	logging.Configure(*flagLogFormat, *flagLogLevel, *flagLogProgress)
 
 	// Check excluding flags
 	loopMode := *flagLoop > 0
@@ -288,9 +627,15 @@ func main() {
 	var modeFunc func() error
 	switch {
 
+	// server mode enabled
+	case *flagServer:
+		modeFunc = runServer
+
 	// loop mode enabled
 	case *flagAlerts && loopMode:
 		modeFunc = loopAlerts
+	case *flagPositions && loopMode && *flagTripUpdates:
+		modeFunc = loopPositionsAndTripUpdates
 	case *flagPositions && loopMode:
 		modeFunc = loopPositions
 	case loopMode:
@@ -303,10 +648,13 @@ func main() {
 		modeFunc = singlePositions
 	}
 
-	// create the target directory
-	err = os.Mkdir(*flagTarget, 0o777)
-	if err != nil && !errors.Is(err, fs.ErrExist) {
-		log.Fatalf("mkdir %s: %v", *flagTarget, err)
+	// create the target directory; -server writes no feed files there, only -smooth's
+	// kalman.json
+	if !*flagServer || *flagSmooth {
+		err = os.Mkdir(*flagTarget, 0o777)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			log.Fatalf("mkdir %s: %v", *flagTarget, err)
+		}
 	}
 
 	// Execute the selected mode